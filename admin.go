@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// monitorState is what a running --monitor exposes on ADMIN_ADDR. It is
+// updated once per cycle and read by the admin handler.
+type monitorState struct {
+	mu         sync.Mutex
+	startedAt  time.Time
+	cfg        Config
+	cycles     int
+	actions    map[string]int
+	lastResult map[string]any
+	lastAt     time.Time
+	lastSwitch time.Time
+	trends     []EndpointTrend
+
+	// Metrics gauges, kept from the last cycle that measured them.
+	switches     map[string]int
+	currentDelay *int
+	endpoints    map[string]bool
+}
+
+func newMonitorState(cfg Config) *monitorState {
+	return &monitorState{startedAt: time.Now(), cfg: cfg, actions: make(map[string]int), switches: make(map[string]int)}
+}
+
+func (s *monitorState) record(cfg Config, result map[string]any) {
+	if s == nil || result == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	s.cycles++
+	action, _ := result["action"].(string)
+	if action == "" {
+		action = "error"
+	}
+	s.actions[action]++
+	s.lastResult = result
+	s.lastAt = time.Now()
+	if action == "switched" {
+		s.lastSwitch = s.lastAt
+		switchType, _ := result["switch_type"].(string)
+		s.switches[switchType]++
+	}
+	s.recordGauges(action, result)
+}
+
+func (s *monitorState) setEndpointTrends(trends []EndpointTrend) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trends = trends
+}
+
+func (s *monitorState) snapshot() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	actions := make(map[string]any, len(s.actions))
+	for action, count := range s.actions {
+		actions[action] = count
+	}
+	stats := map[string]any{
+		"started_at":     s.startedAt.UTC().Format(time.RFC3339),
+		"uptime_s":       int(time.Since(s.startedAt).Seconds()),
+		"cycles":         s.cycles,
+		"actions":        actions,
+		"last_switch_at": nil,
+	}
+	if !s.lastSwitch.IsZero() {
+		stats["last_switch_at"] = s.lastSwitch.UTC().Format(time.RFC3339)
+	}
+	var last any
+	if s.lastResult != nil {
+		decision := make(map[string]any, len(s.lastResult)+1)
+		for key, value := range s.lastResult {
+			decision[key] = value
+		}
+		decision["time"] = s.lastAt.UTC().Format(time.RFC3339)
+		last = decision
+	}
+	snapshot := map[string]any{
+		"config":         configSnapshot(s.cfg),
+		"last_decision":  last,
+		"paused":         paused(s.cfg),
+		"schema_version": jsonSchemaVersion,
+		"stats":          stats,
+	}
+	if s.trends != nil {
+		snapshot["endpoint_history"] = s.trends
+	}
+	if s.cfg.delays != nil {
+		snapshot["delay_cache"] = s.cfg.delays.snapshot(time.Now())
+	}
+	return snapshot
+}
+
+// configSnapshot reports the effective settings with secrets and proxy
+// credentials redacted.
+func configSnapshot(cfg Config) map[string]any {
+	redact := func(secret string) any {
+		if secret == "" {
+			return ""
+		}
+		return "<redacted>"
+	}
+	orEmpty := func(list []string) []string {
+		if list == nil {
+			return []string{}
+		}
+		return list
+	}
+	regexpStrings := func(list []*regexp.Regexp) []string {
+		out := make([]string, 0, len(list))
+		for _, re := range list {
+			out = append(out, re.String())
+		}
+		return out
+	}
+	return map[string]any{
+		"controller_url":           cfg.ControllerURL,
+		"controller_secret":        redact(cfg.ControllerSecret),
+		"controller_basic_user":    cfg.ControllerBasicUser,
+		"controller_basic_pass":    redact(cfg.ControllerBasicPass),
+		"controller_url_fallback":  cfg.ControllerFallback,
+		"controller_ca_file":       cfg.ControllerCAFile,
+		"controller_insecure":      cfg.ControllerInsecure,
+		"proxy_group":              cfg.ProxyGroup,
+		"test_url":                 cfg.TestURL,
+		"delay_timeout_ms":         cfg.DelayTimeoutMS,
+		"auto_select_diff_ms":      cfg.AutoSelectDiffMS,
+		"monitor_interval_s":       cfg.MonitorIntervalS,
+		"keep_delay_threshold_ms":  cfg.KeepDelayThresholdMS,
+		"endpoint_urls":            orEmpty(cfg.EndpointURLs),
+		"proxy_addr":               redactURLPassword(cfg.ProxyAddr),
+		"filter_hk_nodes":          cfg.FilterHKNodes,
+		"filter_info_nodes":        cfg.FilterInfoNodes,
+		"info_node_patterns":       regexpStrings(cfg.InfoNodePatterns),
+		"select_strategy":          cfg.SelectStrategy,
+		"probe_order":              cfg.ProbeOrder,
+		"delay_samples":            cfg.DelaySamples,
+		"refresh_before_read":      cfg.RefreshBeforeRead,
+		"delay_trim":               cfg.DelayTrim,
+		"score_expr":               cfg.ScoreExpr != nil,
+		"endpoint_weight":          cfg.EndpointWeight,
+		"sticky_proxies":           regexpStrings(cfg.StickyProxies),
+		"force_off_direct":         cfg.ForceOffDirect,
+		"close_conns_on_switch":    cfg.CloseConnsOnSwitch,
+		"avoid_same_exit_ip":       cfg.AvoidSameExitIP,
+		"gate_command":             cfg.GateCommand != "",
+		"result_sink_url":          redactURLPassword(cfg.ResultSinkURL),
+		"notify_webhook_url":       redact(cfg.NotifyWebhookURL),
+		"webhook_batch_interval_s": cfg.WebhookBatchS,
+		"lock_file":                cfg.LockFile,
+		"pause_file":               cfg.PauseFile,
+		"max_delay_age_s":          cfg.MaxDelayAgeS,
+		"endpoint_retry":           cfg.EndpointRetry,
+		"endpoint_network":         cfg.EndpointNetwork,
+		"endpoint_method":          cfg.EndpointMethod,
+		"endpoint_switch_statuses": cfg.SwitchStatuses,
+		"log_level":                cfg.LogLevel.String(),
+		"keep_if_rank_within":      cfg.KeepIfRankWithin,
+	}
+}
+
+func redactURLPassword(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+	return u.String()
+}
+
+// adminListenAddr binds a bare port (":9090" or "9090") to localhost so the
+// admin endpoint is never exposed unless a host is given explicitly.
+func adminListenAddr(raw string) string {
+	if !strings.Contains(raw, ":") {
+		return "127.0.0.1:" + raw
+	}
+	if strings.HasPrefix(raw, ":") {
+		return "127.0.0.1" + raw
+	}
+	return raw
+}
+
+func adminHandler(state *monitorState) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, mustASCIIJSON(state.snapshot()))
+	})
+	return mux
+}
+
+// startAdminServer serves the read-only admin endpoint until close is called.
+func startAdminServer(addr string, state *monitorState) (func(), error) {
+	listener, err := net.Listen("tcp", adminListenAddr(addr))
+	if err != nil {
+		return nil, fmt.Errorf("ADMIN_ADDR: %w", err)
+	}
+	server := &http.Server{Handler: adminHandler(state), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logErrorf("Admin server stopped: %v", err)
+		}
+	}()
+	logInfof("Admin endpoint listening on http://%s/state", listener.Addr())
+	return func() { _ = server.Close() }, nil
+}
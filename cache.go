@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// delayCache holds the latest group delays sampled by a background refresher
+// so read paths (the admin /state endpoint, --watch) never wait on the
+// controller. A nil *delayCache is a disabled cache.
+type delayCache struct {
+	ttl     time.Duration
+	refresh chan struct{}
+
+	mu          sync.RWMutex
+	delays      []ProxyDelay
+	fetchedAt   time.Time
+	invalidated bool
+}
+
+func newDelayCache(ttl time.Duration) *delayCache {
+	return &delayCache{ttl: ttl, refresh: make(chan struct{}, 1)}
+}
+
+func (c *delayCache) store(delays []ProxyDelay, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delays = append([]ProxyDelay(nil), delays...)
+	c.fetchedAt = at
+	c.invalidated = false
+}
+
+// get returns a copy of the cached delays and when they were sampled; ok is
+// false until the first successful refresh.
+func (c *delayCache) get() ([]ProxyDelay, time.Time, bool) {
+	if c == nil {
+		return nil, time.Time{}, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.fetchedAt.IsZero() {
+		return nil, time.Time{}, false
+	}
+	return append([]ProxyDelay(nil), c.delays...), c.fetchedAt, true
+}
+
+// stale reports whether the cached delays are older than the TTL (refreshes
+// are failing) or were invalidated and not refreshed since.
+func (c *delayCache) stale(now time.Time) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.invalidated || c.fetchedAt.IsZero() || now.Sub(c.fetchedAt) > c.ttl
+}
+
+// invalidate marks the cache stale and asks the refresher to sample again
+// right away instead of waiting out the TTL.
+func (c *delayCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.invalidated = true
+	c.mu.Unlock()
+	select {
+	case c.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// run refreshes the cache every TTL (or on invalidate) until stop is closed.
+// A failed refresh keeps the previous delays.
+func (c *delayCache) run(fetch func() []ProxyDelay, stop <-chan struct{}) {
+	for {
+		if delays := fetch(); len(delays) > 0 {
+			sortDelays(delays)
+			c.store(delays, time.Now())
+		} else {
+			logWarnf("Delay cache refresh returned no delay data, keeping previous delays")
+		}
+		timer := time.NewTimer(c.ttl)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-c.refresh:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// snapshot reports the cache for the admin /state endpoint.
+func (c *delayCache) snapshot(now time.Time) map[string]any {
+	delays, fetchedAt, ok := c.get()
+	if !ok {
+		return map[string]any{"fetched_at": nil, "age_s": nil, "stale": true, "delays": []any{}}
+	}
+	entries := make([]map[string]any, 0, len(delays))
+	for _, item := range delays {
+		entries = append(entries, map[string]any{"name": item.Name, "delay_ms": item.DelayMS})
+	}
+	return map[string]any{
+		"fetched_at": fetchedAt.UTC().Format(time.RFC3339),
+		"age_s":      int(now.Sub(fetchedAt).Seconds()),
+		"stale":      c.stale(now),
+		"delays":     entries,
+	}
+}
+
+// startDelayCache starts refreshing the group delays every CACHE_TTL_S in the
+// background. The refresher has its own controller state, so its requests
+// neither count toward a monitor cycle's controller load nor change that
+// cycle's failover. The returned function stops the refresher.
+func startDelayCache(client *http.Client, cfg Config) (*delayCache, func()) {
+	cfg.controller = &controllerState{}
+	cache := newDelayCache(time.Duration(cfg.CacheTTLS) * time.Second)
+	stop := make(chan struct{})
+	go cache.run(func() []ProxyDelay { return getGroupDelays(client, cfg) }, stop)
+	return cache, func() { close(stop) }
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDelayCacheStaleness(t *testing.T) {
+	cache := newDelayCache(time.Minute)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, _, ok := cache.get(); ok || !cache.stale(now) {
+		t.Fatalf("empty cache should report no data and be stale")
+	}
+	if snapshot := cache.snapshot(now); snapshot["fetched_at"] != nil || snapshot["stale"] != true {
+		t.Fatalf("unexpected empty snapshot %#v", snapshot)
+	}
+
+	cache.store([]ProxyDelay{{Name: "A", DelayMS: 100}}, now)
+	delays, fetchedAt, ok := cache.get()
+	if !ok || len(delays) != 1 || !fetchedAt.Equal(now) {
+		t.Fatalf("unexpected cached delays %v %v %v", delays, fetchedAt, ok)
+	}
+	delays[0].DelayMS = 1
+	if again, _, _ := cache.get(); again[0].DelayMS != 100 {
+		t.Fatalf("get must return a copy, cache was modified")
+	}
+	if cache.stale(now.Add(30 * time.Second)) {
+		t.Fatalf("cache within TTL should be fresh")
+	}
+	if !cache.stale(now.Add(2 * time.Minute)) {
+		t.Fatalf("cache older than TTL should be stale")
+	}
+	snapshot := cache.snapshot(now.Add(90 * time.Second))
+	if snapshot["age_s"] != 90 || snapshot["stale"] != true {
+		t.Fatalf("unexpected snapshot %#v", snapshot)
+	}
+
+	cache.invalidate()
+	if !cache.stale(now.Add(time.Second)) {
+		t.Fatalf("invalidated cache should be stale until refreshed")
+	}
+	cache.store([]ProxyDelay{{Name: "A", DelayMS: 90}}, now.Add(time.Second))
+	if cache.stale(now.Add(2 * time.Second)) {
+		t.Fatalf("refresh should clear invalidation")
+	}
+}
+
+func TestDelayCacheRefreshesOnInvalidate(t *testing.T) {
+	cache := newDelayCache(time.Hour)
+	var fetches atomic.Int32
+	fetched := make(chan struct{}, 4)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cache.run(func() []ProxyDelay {
+			n := int(fetches.Add(1))
+			defer func() { fetched <- struct{}{} }()
+			return []ProxyDelay{{Name: "B", DelayMS: 300 - n}, {Name: "A", DelayMS: 100}}
+		}, stop)
+	}()
+
+	waitFetch := func() {
+		t.Helper()
+		select {
+		case <-fetched:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("refresh did not happen")
+		}
+	}
+	waitFetch()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if delays, _, ok := cache.get(); ok {
+			if delays[0].Name != "A" {
+				t.Fatalf("cached delays should be sorted, got %v", delays)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cache was not filled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cache.invalidate()
+	waitFetch()
+	close(stop)
+	wg.Wait()
+	if got := fetches.Load(); got != 2 {
+		t.Fatalf("expected one refresh per invalidate within the TTL, got %d fetches", got)
+	}
+}
+
+func TestDelayCacheUsesOwnControllerState(t *testing.T) {
+	fc := &fakeController{now: "A", groupDelays: map[string]any{"A": 120, "B": 50}}
+	server := newFakeController(t, fc)
+	cfg := Config{
+		ControllerURL:  server.URL,
+		ProxyGroup:     "GLOBAL",
+		TestURL:        "https://example.com",
+		DelayTimeoutMS: 3000,
+		CacheTTLS:      3600,
+		controller:     &controllerState{},
+	}
+
+	cache, stop := startDelayCache(server.Client(), cfg)
+	defer stop()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, _, ok := cache.get(); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cache was not filled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if requests, _ := cfg.controller.cycleLoad(); requests != 0 {
+		t.Fatalf("cache refresh counted %d requests in the monitor's cycle load", requests)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const controllerCheckTimeout = 5 * time.Second
+
+// checkController asks the controller at baseURL for /version, falling back
+// to / for controllers without it, and reports whether it answered, how fast,
+// and whether the secret was accepted.
+func checkController(client *http.Client, cfg Config, baseURL, secret string) map[string]any {
+	report := map[string]any{"controller_url": baseURL, "reachable": false, "auth_ok": nil, "latency_ms": nil}
+	start := time.Now()
+	payload, err := doControllerRequestAuth(context.Background(), client, cfg.controller, controllerBasicAuth(cfg), secret, http.MethodGet, baseURL+"/version", nil, controllerCheckTimeout)
+	var statusErr *controllerStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		start = time.Now()
+		payload, err = doControllerRequestAuth(context.Background(), client, cfg.controller, controllerBasicAuth(cfg), secret, http.MethodGet, baseURL+"/", nil, controllerCheckTimeout)
+	}
+	latencyMS := int(time.Since(start).Milliseconds())
+
+	var nonJSON *nonJSONError
+	switch {
+	case err == nil:
+		report["reachable"], report["auth_ok"], report["latency_ms"] = true, true, latencyMS
+		if version, ok := payload["version"].(string); ok {
+			report["version"] = version
+		}
+		if meta, ok := payload["meta"].(bool); ok {
+			report["meta"] = meta
+		}
+	case errors.As(err, &statusErr):
+		report["reachable"], report["latency_ms"] = true, latencyMS
+		if statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden {
+			report["auth_ok"] = false
+		}
+		report["error"] = err.Error()
+	case errors.As(err, &nonJSON):
+		report["reachable"], report["latency_ms"] = true, latencyMS
+		report["error"] = err.Error()
+	default:
+		report["error"] = err.Error()
+	}
+	return report
+}
+
+// controllerCheckOK reports whether a checkController report shows a usable
+// controller.
+func controllerCheckOK(report map[string]any) bool {
+	return report["reachable"] == true && report["auth_ok"] == true
+}
+
+func formatControllerCheck(report map[string]any) string {
+	url, _ := report["controller_url"].(string)
+	if report["reachable"] != true {
+		return fmt.Sprintf("controller\t%s\tunreachable\t(%s)", url, report["error"])
+	}
+	status := "auth ok"
+	switch {
+	case report["auth_ok"] == false:
+		status = "auth failed"
+	case report["auth_ok"] == nil:
+		status = "error"
+	}
+	text := fmt.Sprintf("controller\t%s\treachable\t%dms\t%s", url, report["latency_ms"], status)
+	if version, ok := report["version"].(string); ok {
+		text += "\t" + sanitizeName(version)
+	}
+	if errText, ok := report["error"].(string); ok {
+		text += "\t(" + errText + ")"
+	}
+	return text
+}
+
+// checkControllerOnce is --check-controller: it checks MIHOMO_CONTROLLER_URL
+// and, when set, MIHOMO_CONTROLLER_URL_FALLBACK, and returns the exit code,
+// 0 when the primary is reachable and accepts the secret.
+func checkControllerOnce(client *http.Client, cfg Config, jsonOutput bool) int {
+	report := checkController(client, cfg, cfg.ControllerURL, cfg.ControllerSecret)
+	var fallback map[string]any
+	if cfg.ControllerFallback != "" {
+		secret := cfg.FallbackSecret
+		if secret == "" {
+			secret = cfg.ControllerSecret
+		}
+		fallback = checkController(client, cfg, cfg.ControllerFallback, secret)
+	}
+
+	if jsonOutput {
+		if fallback != nil {
+			report["fallback"] = fallback
+		}
+		printJSON(report)
+	} else {
+		fmt.Println(formatControllerCheck(report))
+		if fallback != nil {
+			fmt.Println(formatControllerCheck(fallback))
+		}
+	}
+	if controllerCheckOK(report) {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckController(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"version": "v1.18.0", "meta": true})
+	}))
+	t.Cleanup(server.Close)
+
+	report := checkController(server.Client(), Config{}, server.URL, "good")
+	if !controllerCheckOK(report) || report["version"] != "v1.18.0" || report["latency_ms"] == nil {
+		t.Fatalf("unexpected report for a good secret: %v", report)
+	}
+	if text := formatControllerCheck(report); !strings.HasPrefix(text, "controller\t"+server.URL+"\treachable\t") || !strings.HasSuffix(text, "\tauth ok\tv1.18.0") {
+		t.Fatalf("unexpected text %q", text)
+	}
+
+	report = checkController(server.Client(), Config{}, server.URL, "wrong")
+	if controllerCheckOK(report) || report["reachable"] != true || report["auth_ok"] != false {
+		t.Fatalf("unexpected report for a wrong secret: %v", report)
+	}
+	report = checkController(server.Client(), Config{}, server.URL, "wrong,good")
+	if !controllerCheckOK(report) {
+		t.Fatalf("expected the secret list to be tried in order: %v", report)
+	}
+
+	report = checkController(&http.Client{Timeout: time.Second}, Config{}, "http://127.0.0.1:1", "")
+	if report["reachable"] != false || report["auth_ok"] != nil || report["error"] == nil {
+		t.Fatalf("unexpected report for an unreachable controller: %v", report)
+	}
+	if text := formatControllerCheck(report); !strings.Contains(text, "\tunreachable\t(") {
+		t.Fatalf("unexpected text %q", text)
+	}
+}
+
+func TestCheckControllerFallsBackToRoot(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"hello": "clash"})
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := Config{ControllerURL: server.URL, ControllerFallback: "http://127.0.0.1:1"}
+	var code int
+	raw := captureStdout(t, func() { code = checkControllerOnce(&http.Client{Timeout: time.Second}, cfg, true) })
+	var report map[string]any
+	if err := json.Unmarshal(raw, &report); err != nil {
+		t.Fatalf("decode %q: %v", raw, err)
+	}
+	if code != 0 || report["auth_ok"] != true {
+		t.Fatalf("expected / to stand in for /version, got code %d, %v", code, report)
+	}
+	fallback, _ := report["fallback"].(map[string]any)
+	if fallback == nil || fallback["reachable"] != false {
+		t.Fatalf("expected the fallback controller to be reported, got %v", report)
+	}
+}
+
+func TestParseArgsCheckController(t *testing.T) {
+	args, err := parseArgsFrom([]string{"--check-controller", "--json"})
+	if err != nil || !args.CheckController || !args.JSONOutput {
+		t.Fatalf("unexpected args %+v, err %v", args, err)
+	}
+	if _, err := parseArgsFrom([]string{"--check-controller", "--monitor"}); err == nil {
+		t.Fatal("expected --check-controller to be exclusive with other actions")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	configFileMu sync.Mutex
+	// configFileKeys are the variables exported from a --config file, which a
+	// reload may overwrite; anything else in the environment wins over the file.
+	configFileKeys = map[string]bool{}
+)
+
+// loadConfigFile loads the --config JSON file, if any, underneath the
+// environment and .env, then runs loadConfig.
+func loadConfigFile(path string) (Config, error) {
+	if path != "" {
+		if err := applyConfigFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+	cfg, err := loadConfig()
+	cfg.configFile = path
+	return cfg, err
+}
+
+// applyConfigFile exports each key of the JSON object in path as the
+// environment variable of the same name (case-insensitive) unless it is
+// already set. Lists are joined with commas.
+func applyConfigFile(path string) error {
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		return fmt.Errorf("--config %s: only JSON config files are supported", path)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("--config: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var values map[string]any
+	if err := decoder.Decode(&values); err != nil {
+		return fmt.Errorf("--config %s: %w", path, err)
+	}
+
+	configFileMu.Lock()
+	defer configFileMu.Unlock()
+	for key, value := range values {
+		name := strings.ToUpper(strings.TrimSpace(key))
+		text, err := configFileValue(value)
+		if err != nil {
+			return fmt.Errorf("--config %s: %s %v", path, key, err)
+		}
+		if _, set := os.LookupEnv(name); set && !configFileKeys[name] {
+			continue
+		}
+		if err := os.Setenv(name, text); err != nil {
+			return fmt.Errorf("--config %s: %s: %w", path, key, err)
+		}
+		configFileKeys[name] = true
+	}
+	return nil
+}
+
+func configFileValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			text, err := configFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			if _, nested := item.([]any); nested {
+				return "", fmt.Errorf("must not contain nested lists")
+			}
+			items = append(items, text)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("must be a string, number, boolean, or list")
+}
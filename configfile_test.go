@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := chdirTempDir(t)
+	t.Cleanup(func() { configFileKeys = map[string]bool{} })
+	path := filepath.Join(dir, "settings.json")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+	}
+	for _, name := range []string{"MIHOMO_CONTROLLER_URL", "DELAY_TIMEOUT_MS", "ENDPOINT_URLS", "FILTER_HK_NODES"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	t.Setenv("AUTO_SELECT_DIFF_MS", "450")
+
+	write(`{
+		"MIHOMO_CONTROLLER_URL": "http://127.0.0.1:9090",
+		"delay_timeout_ms": 1500,
+		"ENDPOINT_URLS": ["https://a.example", "https://b.example"],
+		"FILTER_HK_NODES": false,
+		"AUTO_SELECT_DIFF_MS": 100
+	}`)
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+	if cfg.ControllerURL != "http://127.0.0.1:9090" || cfg.DelayTimeoutMS != 1500 || cfg.FilterHKNodes {
+		t.Fatalf("file values not applied: %+v", cfg)
+	}
+	if strings.Join(cfg.EndpointURLs, ",") != "https://a.example,https://b.example" {
+		t.Fatalf("list not joined: %v", cfg.EndpointURLs)
+	}
+	if cfg.AutoSelectDiffMS != 450 {
+		t.Fatalf("environment should take precedence, got %d", cfg.AutoSelectDiffMS)
+	}
+	if cfg.configFile != path {
+		t.Fatalf("config file path not kept for reloads: %q", cfg.configFile)
+	}
+
+	// A reload picks up edits to keys that came from the file.
+	write(`{"MIHOMO_CONTROLLER_URL": "http://127.0.0.1:9090", "DELAY_TIMEOUT_MS": 2500}`)
+	cfg = reloadConfig(cfg, func() (Config, error) { return loadConfigFile(path) })
+	if cfg.DelayTimeoutMS != 2500 {
+		t.Fatalf("reload did not apply the edited file, got %d", cfg.DelayTimeoutMS)
+	}
+
+	for content, want := range map[string]string{
+		`not json`:                     "settings.json",
+		`{"MONITOR_GROUPS": {"a": 1}}`: "MONITOR_GROUPS",
+		`{"ENDPOINT_URLS": [["a"]]}`:   "nested lists",
+	} {
+		write(content)
+		if _, err := loadConfigFile(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error containing %q for %s, got %v", want, content, err)
+		}
+	}
+	if _, err := loadConfigFile(filepath.Join(dir, "settings.yaml")); err == nil || !strings.Contains(err.Error(), "only JSON") {
+		t.Fatalf("expected YAML to be rejected, got %v", err)
+	}
+	if _, err := loadConfigFile(filepath.Join(dir, "missing.json")); err == nil || !strings.Contains(err.Error(), "--config") {
+		t.Fatalf("expected a missing file error, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// printDelaysCSV is --print-delays --csv: a delay_ms,name header and one row
+// per node, fastest first. Names are written raw, quoted as CSV requires.
+// With several MIHOMO_PROXY_GROUP entries a leading group column is added.
+// A no-data reason goes to the log so stdout stays valid CSV.
+func printDelaysCSV(client *http.Client, cfg Config) {
+	groups := proxyGroups(cfg)
+	multi := len(groups) > 1
+	w := csv.NewWriter(os.Stdout)
+	if multi {
+		_ = w.Write([]string{"group", "delay_ms", "name"})
+	} else {
+		_ = w.Write([]string{"delay_ms", "name"})
+	}
+	for _, group := range groups {
+		groupCfg := cfg
+		groupCfg.ProxyGroup = group
+		delays, reason := topGroupDelays(client, groupCfg)
+		if len(delays) == 0 {
+			logWarnf("%s: %s", group, noDataText(reason))
+		}
+		for _, item := range delays {
+			row := []string{strconv.Itoa(item.DelayMS), item.Name}
+			if multi {
+				row = append([]string{group}, row...)
+			}
+			_ = w.Write(row)
+		}
+	}
+	w.Flush()
+}
+
+// checkEndpointsCSVOnce is --check-endpoints --csv: a reachable,latency_ms,url
+// header and one row per endpoint, with the usual exit codes. With
+// CHECK_DIRECT_BASELINE, direct_latency_ms and proxy_overhead_ms columns are
+// added, empty when there is no value. Configuration errors go to stderr.
+func checkEndpointsCSVOnce(cfg Config, dualStack bool) int {
+	if len(cfg.EndpointURLs) == 0 {
+		fmt.Fprintln(os.Stderr, "ENDPOINT_URLS is empty")
+		return endpointsUnchecked
+	}
+	if strings.TrimSpace(cfg.ProxyAddr) == "" {
+		fmt.Fprintln(os.Stderr, "MIHOMO_PROXY_ADDR is empty")
+		return endpointsUnchecked
+	}
+	results, allReachable := checkCurrentEndpoints(cfg, dualStack)
+	w := csv.NewWriter(os.Stdout)
+	header := []string{"reachable", "latency_ms", "url"}
+	if cfg.CheckDirectBaseline {
+		header = append(header, "direct_latency_ms", "proxy_overhead_ms")
+	}
+	_ = w.Write(header)
+	for _, item := range results {
+		row := []string{strconv.FormatBool(item.Reachable), strconv.Itoa(item.LatencyMS), item.URL}
+		if cfg.CheckDirectBaseline {
+			row = append(row, csvOptionalInt(item.DirectLatencyMS), csvOptionalInt(item.OverheadMS))
+		}
+		_ = w.Write(row)
+	}
+	w.Flush()
+	return endpointsExitCode(allReachable)
+}
+
+func csvOptionalInt(value *int) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.Itoa(*value)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseArgsCSVValidation(t *testing.T) {
+	args, err := parseArgsFrom([]string{"--print-delays", "--csv"})
+	if err != nil || !args.CSVOutput {
+		t.Fatalf("expected --csv with --print-delays, got %+v, %v", args, err)
+	}
+	if _, err := parseArgsFrom([]string{"--check-endpoints", "--csv"}); err != nil {
+		t.Fatalf("expected --csv with --check-endpoints, got %v", err)
+	}
+	for _, argv := range [][]string{
+		{"--check-endpoints", "--csv", "--dual-stack"},
+		{"--print-delays", "--csv", "--json"},
+		{"--print-delays", "--csv", "--histogram"},
+		{"--print-delays", "--csv", "--stats"},
+		{"--auto-select", "--csv"},
+	} {
+		if _, err := parseArgsFrom(argv); err == nil {
+			t.Fatalf("expected %v to be rejected", argv)
+		}
+	}
+}
+
+func TestPrintDelaysCSV(t *testing.T) {
+	fc := &fakeController{now: "A", groupDelays: map[string]any{"A": 120, "B, \"fast\"": 50}}
+	server := newFakeController(t, fc)
+	cfg := Config{ControllerURL: server.URL, ProxyGroup: "GLOBAL", TestURL: "https://example.com", DelayTimeoutMS: 3000}
+
+	got := string(captureStdout(t, func() { printDelaysCSV(server.Client(), cfg) }))
+	want := "delay_ms,name\n50,\"B, \"\"fast\"\"\"\n120,A\n"
+	if got != want {
+		t.Fatalf("unexpected CSV:\n%s\nwant:\n%s", got, want)
+	}
+
+	cfg.ProxyGroups = []string{"GLOBAL", "Auto"}
+	got = string(captureStdout(t, func() { printDelaysCSV(server.Client(), cfg) }))
+	want = "group,delay_ms,name\nGLOBAL,50,\"B, \"\"fast\"\"\"\nGLOBAL,120,A\nAuto,50,\"B, \"\"fast\"\"\"\nAuto,120,A\n"
+	if got != want {
+		t.Fatalf("unexpected multi-group CSV:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCheckEndpointsCSV(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "down.test" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(proxy.Close)
+	cfg := Config{ProxyAddr: proxy.URL, EndpointURLs: []string{"http://up.test/", "http://down.test/"}}
+
+	var code int
+	out := string(captureStdout(t, func() { code = checkEndpointsCSVOnce(cfg, false) }))
+	if code != endpointsDegraded {
+		t.Fatalf("expected exit code %d, got %d", endpointsDegraded, code)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || lines[0] != "reachable,latency_ms,url" ||
+		!strings.HasPrefix(lines[1], "true,") || !strings.HasSuffix(lines[1], ",http://up.test/") ||
+		!strings.HasPrefix(lines[2], "false,") || !strings.HasSuffix(lines[2], ",http://down.test/") {
+		t.Fatalf("unexpected CSV:\n%s", out)
+	}
+
+	cfg.CheckDirectBaseline = true
+	out = string(captureStdout(t, func() { checkEndpointsCSVOnce(cfg, false) }))
+	lines = strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || lines[0] != "reachable,latency_ms,url,direct_latency_ms,proxy_overhead_ms" ||
+		!strings.HasSuffix(lines[2], ",http://down.test/,-1,") {
+		t.Fatalf("unexpected CSV with CHECK_DIRECT_BASELINE:\n%s", out)
+	}
+
+	cfg.EndpointURLs = nil
+	if code := checkEndpointsCSVOnce(cfg, false); code != endpointsUnchecked {
+		t.Fatalf("expected exit code %d without ENDPOINT_URLS, got %d", endpointsUnchecked, code)
+	}
+}
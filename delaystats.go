@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// summarizeDelays is the --print-delays --stats summary of every measured
+// delay: count, min, max, mean, median and population standard deviation.
+// Statistics are nil when nothing was measured.
+func summarizeDelays(delays []ProxyDelay) map[string]any {
+	summary := map[string]any{"count": len(delays), "min_ms": nil, "max_ms": nil, "mean_ms": nil, "median_ms": nil, "stddev_ms": nil}
+	if len(delays) == 0 {
+		return summary
+	}
+	values := make([]int, 0, len(delays))
+	minMS, maxMS, total := delays[0].DelayMS, delays[0].DelayMS, 0
+	for _, item := range delays {
+		values = append(values, item.DelayMS)
+		minMS = min(minMS, item.DelayMS)
+		maxMS = max(maxMS, item.DelayMS)
+		total += item.DelayMS
+	}
+	mean := float64(total) / float64(len(values))
+	variance := 0.0
+	for _, value := range values {
+		variance += (float64(value) - mean) * (float64(value) - mean)
+	}
+	summary["min_ms"], summary["max_ms"] = minMS, maxMS
+	summary["mean_ms"] = math.Round(mean*10) / 10
+	summary["median_ms"] = medianSample(values)
+	summary["stddev_ms"] = math.Round(math.Sqrt(variance/float64(len(values)))*10) / 10
+	return summary
+}
+
+// countFilteredMembers returns how many group members the name filters
+// (FILTER_HK_NODES and friends) removed, or -1 when the group lookup fails.
+func countFilteredMembers(client *http.Client, cfg Config) int {
+	info, err := getGroupInfo(context.Background(), client, cfg)
+	if err != nil {
+		logWarnf("Group member lookup failed, filtered count unavailable: %v", err)
+		return -1
+	}
+	filtered := 0
+	for _, name := range info.All {
+		if isFilteredProxy(name, cfg) {
+			filtered++
+		}
+	}
+	return filtered
+}
+
+// printDelayStatsOnce is --print-delays --stats: the usual top ten, followed
+// by a summary of all measured delays.
+func printDelayStatsOnce(client *http.Client, cfg Config, jsonOutput bool) {
+	all, reason := getGroupDelaysWithReason(client, cfg, cfg.FilterHKNodes)
+	sortDelays(all)
+	top := all
+	if len(top) > 10 {
+		top = top[:10]
+	}
+	summary := summarizeDelays(all)
+	filtered := countFilteredMembers(client, cfg)
+	if filtered >= 0 {
+		summary["filtered"] = filtered
+	} else {
+		summary["filtered"] = nil
+	}
+
+	if jsonOutput {
+		payload := map[string]any{"test_url": cfg.TestURL, "summary": summary}
+		if len(top) == 0 {
+			for key, value := range noDataResult(reason) {
+				payload[key] = value
+			}
+		}
+		entries := make([]map[string]any, 0, len(top))
+		for _, item := range top {
+			entries = append(entries, addDelayHuman(map[string]any{"name": item.Name, "delay_ms": item.DelayMS}, item.DelayMS, cfg))
+		}
+		payload["delays"] = entries
+		printJSON(payload)
+		return
+	}
+	fmt.Printf("test_url\t%s\n", cfg.TestURL)
+	if len(top) == 0 {
+		fmt.Println(noDataText(reason))
+	}
+	for _, item := range top {
+		fmt.Printf("%s\t%s\n", formatDelay(item.DelayMS, cfg), sanitizeName(item.Name))
+	}
+	filteredText := "n/a"
+	if filtered >= 0 {
+		filteredText = fmt.Sprint(filtered)
+	}
+	if len(all) == 0 {
+		fmt.Printf("summary\tcount=0\tfiltered=%s\n", filteredText)
+		return
+	}
+	fmt.Printf("summary\tcount=%d\tmin=%s\tmax=%s\tmean=%.1fms\tmedian=%s\tstddev=%.1fms\tfiltered=%s\n",
+		len(all), formatDelay(summary["min_ms"].(int), cfg), formatDelay(summary["max_ms"].(int), cfg),
+		summary["mean_ms"], formatDelay(summary["median_ms"].(int), cfg), summary["stddev_ms"], filteredText)
+}
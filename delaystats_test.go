@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSummarizeDelays(t *testing.T) {
+	summary := summarizeDelays([]ProxyDelay{{Name: "A", DelayMS: 100}, {Name: "B", DelayMS: 200}, {Name: "C", DelayMS: 300}, {Name: "D", DelayMS: 1000}})
+	want := map[string]any{"count": 4, "min_ms": 100, "max_ms": 1000, "mean_ms": 400.0, "median_ms": 250, "stddev_ms": 353.6}
+	for key, value := range want {
+		if summary[key] != value {
+			t.Fatalf("summary[%s] = %v, want %v (%v)", key, summary[key], value, summary)
+		}
+	}
+	if empty := summarizeDelays(nil); empty["count"] != 0 || empty["mean_ms"] != nil {
+		t.Fatalf("unexpected empty summary %v", empty)
+	}
+}
+
+func TestPrintDelayStatsOnce(t *testing.T) {
+	fc := &fakeController{
+		now:         "JP-01",
+		all:         []string{"JP-01", "SG-01", "HK-01", "US-01"},
+		groupDelays: map[string]any{"JP-01": 100, "SG-01": 300, "HK-01": 50, "US-01": 0},
+	}
+	server := newFakeController(t, fc)
+	cfg := Config{ControllerURL: server.URL, ProxyGroup: "GLOBAL", TestURL: "https://example.com", DelayTimeoutMS: 100, FilterHKNodes: true}
+
+	out := string(captureStdout(t, func() { printDelayStatsOnce(server.Client(), cfg, false) }))
+	if !strings.Contains(out, "100ms\tJP-01\n") || !strings.Contains(out, "summary\tcount=3\tmin=0ms\tmax=300ms\tmean=133.3ms\tmedian=100ms\tstddev=124.7ms\tfiltered=1\n") {
+		t.Fatalf("unexpected text output %q", out)
+	}
+
+	payload := decodeJSONOutput(t, captureStdout(t, func() { printDelayStatsOnce(server.Client(), cfg, true) }))
+	summary, _ := payload["summary"].(map[string]any)
+	delays, _ := payload["delays"].([]any)
+	if len(delays) != 3 || summary["count"] != float64(3) || summary["filtered"] != float64(1) || summary["median_ms"] != float64(100) {
+		t.Fatalf("unexpected JSON output %v", payload)
+	}
+}
+
+func TestParseArgsPrintDelaysStats(t *testing.T) {
+	args, err := parseArgsFrom([]string{"--print-delays", "--stats"})
+	if err != nil || !args.PrintDelays || !args.Stats {
+		t.Fatalf("expected --stats to modify --print-delays, got %+v %v", args, err)
+	}
+	if _, err := parseArgsFrom([]string{"--print-delays", "--stats", "--histogram"}); err == nil {
+		t.Fatalf("expected --stats and --histogram to conflict")
+	}
+	if args, err := parseArgsFrom([]string{"--stats"}); err != nil || !args.Stats || args.PrintDelays {
+		t.Fatalf("--stats alone should stay the controller stats action, got %+v %v", args, err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// switchContext is what --monitor remembers about its last switch until the
+// next evaluated cycle measures the new node.
+type switchContext struct {
+	From       string
+	To         string
+	BeforeMS   *int
+	ExpectedMS int
+}
+
+// nextSwitchContext returns the context to carry into the next cycle: a new
+// one after a switch, none once a result has reported the effectiveness, and
+// prev otherwise (skipped or gated cycles do not measure anything).
+func nextSwitchContext(prev *switchContext, result map[string]any) *switchContext {
+	if result["action"] == "switched" {
+		ctx := &switchContext{}
+		ctx.From, _ = result["from"].(string)
+		ctx.To, _ = result["to"].(string)
+		ctx.BeforeMS, _ = result["from_delay_ms"].(*int)
+		ctx.ExpectedMS, _ = result["to_delay_ms"].(int)
+		return ctx
+	}
+	if _, ok := result["switch_effectiveness"]; ok {
+		return nil
+	}
+	return prev
+}
+
+// switchEffectiveness compares the delay measured now against the delay of
+// the node switched away from. The verdict is "improved", "regressed" or
+// "unchanged"; "superseded" when the current node is no longer the one
+// switched to, and "unknown" when the pre-switch delay was not measured.
+func switchEffectiveness(ctx *switchContext, current string, currentDelay *int) map[string]any {
+	if ctx == nil {
+		return nil
+	}
+	verdict := "unknown"
+	switch {
+	case current != ctx.To:
+		verdict = "superseded"
+	case currentDelay == nil:
+		verdict = "regressed"
+	case ctx.BeforeMS == nil:
+	case *currentDelay < *ctx.BeforeMS:
+		verdict = "improved"
+	case *currentDelay > *ctx.BeforeMS:
+		verdict = "regressed"
+	default:
+		verdict = "unchanged"
+	}
+	return map[string]any{
+		"from":        ctx.From,
+		"to":          ctx.To,
+		"before_ms":   ctx.BeforeMS,
+		"expected_ms": ctx.ExpectedMS,
+		"after_ms":    currentDelay,
+		"verdict":     verdict,
+	}
+}
+
+func formatEffectiveness(effect map[string]any) string {
+	delayText := func(value any) string {
+		if delayMS, ok := value.(*int); ok && delayMS != nil {
+			return fmt.Sprintf("%dms", *delayMS)
+		}
+		return "nil"
+	}
+	return fmt.Sprintf("%s %s→%s (%s -> %s, expected %dms)", effect["verdict"], delayText(effect["before_ms"]),
+		delayText(effect["after_ms"]), sanitizeName(effect["from"].(string)), sanitizeName(effect["to"].(string)), effect["expected_ms"])
+}
+
+// logEffectivenessSummary logs the switch verdicts of a --monitor run on
+// shutdown.
+func logEffectivenessSummary(verdicts map[string]int) {
+	if len(verdicts) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(verdicts))
+	for verdict := range verdicts {
+		keys = append(keys, verdict)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, verdict := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%d", verdict, verdicts[verdict]))
+	}
+	logInfof("Switch effectiveness: %s", strings.Join(parts, " "))
+}
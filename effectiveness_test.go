@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSwitchEffectivenessVerdicts(t *testing.T) {
+	ms := func(v int) *int { return &v }
+	ctx := &switchContext{From: "A", To: "B", BeforeMS: ms(420), ExpectedMS: 100}
+	cases := []struct {
+		current string
+		delay   *int
+		want    string
+	}{
+		{"B", ms(110), "improved"},
+		{"B", ms(500), "regressed"},
+		{"B", ms(420), "unchanged"},
+		{"B", nil, "regressed"},
+		{"C", ms(50), "superseded"},
+	}
+	for _, tc := range cases {
+		if got := switchEffectiveness(ctx, tc.current, tc.delay)["verdict"]; got != tc.want {
+			t.Fatalf("current=%s delay=%v: verdict %v want %s", tc.current, tc.delay, got, tc.want)
+		}
+	}
+	if got := switchEffectiveness(&switchContext{To: "B"}, "B", ms(10))["verdict"]; got != "unknown" {
+		t.Fatalf("missing pre-switch delay should be unknown, got %v", got)
+	}
+	if switchEffectiveness(nil, "B", ms(10)) != nil {
+		t.Fatalf("no context should report nothing")
+	}
+	if text := formatEffectiveness(switchEffectiveness(ctx, "B", ms(110))); text != "improved 420ms→110ms (A -> B, expected 100ms)" {
+		t.Fatalf("unexpected text %q", text)
+	}
+}
+
+func TestSwitchEffectivenessAcrossCycles(t *testing.T) {
+	fc := &fakeController{now: "A", groupDelays: map[string]any{"A": 2500, "B": 100}}
+	server := newFakeController(t, fc)
+	cfg := Config{
+		ControllerURL:        server.URL,
+		ProxyGroup:           "GLOBAL",
+		TestURL:              "https://example.com",
+		DelayTimeoutMS:       100,
+		AutoSelectDiffMS:     300,
+		KeepDelayThresholdMS: 2000,
+	}
+
+	first := autoSelectOnce(context.Background(), server.Client(), cfg, false, false)
+	if first["action"] != "switched" || first["switch_effectiveness"] != nil {
+		t.Fatalf("expected a plain switch, got %#v", first)
+	}
+	cfg.lastSwitch = nextSwitchContext(nil, first)
+	if cfg.lastSwitch == nil || cfg.lastSwitch.To != "B" || *cfg.lastSwitch.BeforeMS != 2500 {
+		t.Fatalf("unexpected switch context %+v", cfg.lastSwitch)
+	}
+
+	if kept := nextSwitchContext(cfg.lastSwitch, map[string]any{"action": "skipped"}); kept != cfg.lastSwitch {
+		t.Fatalf("skipped cycles must keep the context")
+	}
+
+	fc.now = "B"
+	fc.groupDelays = map[string]any{"A": 2500, "B": 120}
+	second := autoSelectOnce(context.Background(), server.Client(), cfg, false, false)
+	effect, ok := second["switch_effectiveness"].(map[string]any)
+	if !ok || effect["verdict"] != "improved" || *effect["after_ms"].(*int) != 120 {
+		t.Fatalf("expected improved effectiveness, got %#v", second)
+	}
+	if nextSwitchContext(cfg.lastSwitch, second) != nil {
+		t.Fatalf("context should be cleared once evaluated")
+	}
+}
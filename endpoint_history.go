@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// endpointHistory keeps the last window endpoint check outcomes per URL for
+// --monitor trend logging.
+type endpointHistory struct {
+	window  int
+	order   []string
+	samples map[string][]endpointSample
+}
+
+type endpointSample struct {
+	reachable bool
+	latencyMS int
+}
+
+// EndpointTrend summarizes an endpoint's recent checks. Latency figures cover
+// reachable checks only and are nil when there were none.
+type EndpointTrend struct {
+	URL      string   `json:"url"`
+	Checks   int      `json:"checks"`
+	Failures int      `json:"failures"`
+	MinMS    *int     `json:"min_ms"`
+	AvgMS    *float64 `json:"avg_ms"`
+	MaxMS    *int     `json:"max_ms"`
+	P95MS    *int     `json:"p95_ms"`
+}
+
+func newEndpointHistory(window int) *endpointHistory {
+	if window <= 0 {
+		return nil
+	}
+	return &endpointHistory{window: window, samples: make(map[string][]endpointSample)}
+}
+
+// record adds the "endpoints" summary of an auto-select result.
+func (h *endpointHistory) record(result map[string]any) {
+	if h == nil {
+		return
+	}
+	endpoints, _ := result["endpoints"].([]map[string]any)
+	for _, entry := range endpoints {
+		target, _ := entry["url"].(string)
+		reachable, _ := entry["reachable"].(bool)
+		latency, _ := entry["latency_ms"].(int)
+		if target == "" {
+			continue
+		}
+		if _, seen := h.samples[target]; !seen {
+			h.order = append(h.order, target)
+		}
+		buf := append(h.samples[target], endpointSample{reachable: reachable, latencyMS: latency})
+		if len(buf) > h.window {
+			buf = buf[len(buf)-h.window:]
+		}
+		h.samples[target] = buf
+	}
+}
+
+func (h *endpointHistory) summary() []EndpointTrend {
+	if h == nil {
+		return nil
+	}
+	trends := make([]EndpointTrend, 0, len(h.order))
+	for _, target := range h.order {
+		trend := EndpointTrend{URL: target}
+		latencies := make([]int, 0, len(h.samples[target]))
+		for _, sample := range h.samples[target] {
+			trend.Checks++
+			if !sample.reachable {
+				trend.Failures++
+				continue
+			}
+			latencies = append(latencies, sample.latencyMS)
+		}
+		if len(latencies) > 0 {
+			sort.Ints(latencies)
+			sum := 0
+			for _, v := range latencies {
+				sum += v
+			}
+			minMS, maxMS := latencies[0], latencies[len(latencies)-1]
+			avg := math.Round(float64(sum)/float64(len(latencies))*10) / 10
+			p95 := percentile(latencies, 95)
+			trend.MinMS, trend.MaxMS, trend.AvgMS, trend.P95MS = &minMS, &maxMS, &avg, &p95
+		}
+		trends = append(trends, trend)
+	}
+	return trends
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []int, p int) int {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (h *endpointHistory) logSummary() {
+	for _, trend := range h.summary() {
+		if trend.MinMS == nil {
+			logWarnf("Endpoint trend %s: %d/%d checks failed", trend.URL, trend.Failures, trend.Checks)
+			continue
+		}
+		logInfof("Endpoint trend %s: min=%dms avg=%gms max=%dms p95=%dms failures=%d/%d",
+			trend.URL, *trend.MinMS, *trend.AvgMS, *trend.MaxMS, *trend.P95MS, trend.Failures, trend.Checks)
+	}
+}
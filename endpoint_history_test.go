@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestEndpointHistorySummary(t *testing.T) {
+	h := newEndpointHistory(4)
+	cycle := func(latency int, reachable bool) map[string]any {
+		return map[string]any{"endpoints": []map[string]any{
+			{"url": "https://e1.example", "reachable": reachable, "latency_ms": latency},
+			{"url": "https://e2.example", "reachable": false, "latency_ms": -1},
+		}}
+	}
+	h.record(cycle(900, true)) // falls out of the window
+	h.record(cycle(100, true))
+	h.record(cycle(-1, false))
+	h.record(cycle(300, true))
+	h.record(cycle(200, true))
+
+	trends := h.summary()
+	if len(trends) != 2 {
+		t.Fatalf("expected 2 endpoints, got %+v", trends)
+	}
+	e1 := trends[0]
+	if e1.URL != "https://e1.example" || e1.Checks != 4 || e1.Failures != 1 {
+		t.Fatalf("unexpected counts: %+v", e1)
+	}
+	if *e1.MinMS != 100 || *e1.MaxMS != 300 || *e1.AvgMS != 200 || *e1.P95MS != 300 {
+		t.Fatalf("unexpected latency stats: min=%d avg=%v max=%d p95=%d", *e1.MinMS, *e1.AvgMS, *e1.MaxMS, *e1.P95MS)
+	}
+	if e2 := trends[1]; e2.Failures != 4 || e2.MinMS != nil {
+		t.Fatalf("expected all-failed endpoint without latency stats, got %+v", e2)
+	}
+
+	if newEndpointHistory(0) != nil {
+		t.Fatalf("expected ENDPOINT_HISTORY=0 to disable history")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	if got := percentile(values, 95); got != 100 {
+		t.Fatalf("p95 = %d, want 100", got)
+	}
+	if got := percentile(values, 50); got != 50 {
+		t.Fatalf("p50 = %d, want 50", got)
+	}
+	if got := percentile([]int{7}, 95); got != 7 {
+		t.Fatalf("single-value p95 = %d, want 7", got)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"time"
+)
+
+// EVENT_FORMAT values for RESULT_SINK_URL and NOTIFY_WEBHOOK_URL bodies.
+const (
+	eventFormatJSON        = "json"
+	eventFormatCloudEvents = "cloudevents"
+)
+
+// CloudEvents types of the bodies this program posts.
+const (
+	eventTypeResult       = "io.github.dualface.mihomo-monitor.result"
+	eventTypeSwitch       = "io.github.dualface.mihomo-monitor.switch"
+	eventTypeSwitchDigest = "io.github.dualface.mihomo-monitor.switch.digest"
+	eventTypeAlert        = "io.github.dualface.mihomo-monitor.alert"
+)
+
+// encodeEvent renders a sink or webhook body in the given format and returns
+// it with its Content-Type. CloudEvents use the structured JSON mode, with
+// data holding the plain body.
+func encodeEvent(format, eventType string, data map[string]any, now time.Time) ([]byte, string) {
+	if format != eventFormatCloudEvents {
+		return []byte(mustASCIIJSON(data)), "application/json"
+	}
+	envelope := map[string]any{
+		"specversion":     "1.0",
+		"type":            eventType,
+		"source":          eventSource(),
+		"id":              newEventID(),
+		"time":            now.UTC().Format(time.RFC3339Nano),
+		"datacontenttype": "application/json",
+		"data":            data,
+	}
+	return []byte(mustASCIIJSON(envelope)), "application/cloudevents+json"
+}
+
+func eventSource() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return "/mihomo-monitor/" + host
+}
+
+func newEventID() string {
+	var raw [16]byte
+	_, _ = rand.Read(raw[:])
+	return hex.EncodeToString(raw[:])
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeEventCloudEventsEnvelope(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	body, contentType := encodeEvent(eventFormatCloudEvents, eventTypeSwitch, map[string]any{"to": "B"}, now)
+	if contentType != "application/cloudevents+json" {
+		t.Fatalf("unexpected content type %q", contentType)
+	}
+	var envelope map[string]any
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatalf("invalid JSON %q: %v", body, err)
+	}
+	if envelope["specversion"] != "1.0" || envelope["type"] != eventTypeSwitch || envelope["time"] != "2025-01-02T03:04:05Z" {
+		t.Fatalf("unexpected envelope %#v", envelope)
+	}
+	if source, _ := envelope["source"].(string); !strings.HasPrefix(source, "/mihomo-monitor/") {
+		t.Fatalf("unexpected source %q", source)
+	}
+	if id, _ := envelope["id"].(string); len(id) != 32 {
+		t.Fatalf("expected a 128-bit hex id, got %q", id)
+	}
+	if data, _ := envelope["data"].(map[string]any); data["to"] != "B" {
+		t.Fatalf("data should hold the plain body, got %#v", envelope["data"])
+	}
+	other, _ := encodeEvent(eventFormatCloudEvents, eventTypeSwitch, map[string]any{}, now)
+	var second map[string]any
+	_ = json.Unmarshal(other, &second)
+	if second["id"] == envelope["id"] {
+		t.Fatalf("event ids must be unique")
+	}
+
+	plain, contentType := encodeEvent(eventFormatJSON, eventTypeSwitch, map[string]any{"to": "B"}, now)
+	if contentType != "application/json" || string(plain) != `{"to":"B"}` {
+		t.Fatalf("json format should post the plain body, got %q %q", plain, contentType)
+	}
+}
+
+func TestResultSinkCloudEvents(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- raw
+	}))
+	defer server.Close()
+
+	sink, err := newResultSink(server.URL, eventFormatCloudEvents)
+	if err != nil {
+		t.Fatalf("newResultSink failed: %v", err)
+	}
+	sink.push(map[string]any{"action": "kept"})
+	sink.close(5 * time.Second)
+
+	r := <-received
+	if got := r.Header.Get("Content-Type"); got != "application/cloudevents+json" {
+		t.Fatalf("unexpected content type %q", got)
+	}
+	var envelope map[string]any
+	_ = json.Unmarshal(<-bodies, &envelope)
+	data, _ := envelope["data"].(map[string]any)
+	if envelope["type"] != eventTypeResult || data["action"] != "kept" || data["schema_version"] != float64(jsonSchemaVersion) {
+		t.Fatalf("unexpected sink event %#v", envelope)
+	}
+}
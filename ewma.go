@@ -0,0 +1,58 @@
+package main
+
+import "math"
+
+// ewmaExpireCycles is how many --monitor cycles a node may go unanswered
+// before its DELAY_EWMA_ALPHA average is dropped; a node answering again
+// after that starts over from its new sample.
+const ewmaExpireCycles = 10
+
+type ewmaEntry struct {
+	value    float64
+	lastSeen int
+}
+
+// delayEWMA keeps an exponentially weighted moving average of each node's
+// delay across --monitor cycles.
+type delayEWMA struct {
+	cycle   int
+	entries map[string]*ewmaEntry
+}
+
+func newDelayEWMA() *delayEWMA {
+	return &delayEWMA{entries: make(map[string]*ewmaEntry)}
+}
+
+// update folds one cycle's delays into the averages with weight alpha for the
+// new sample, and drops nodes not seen for ewmaExpireCycles.
+func (e *delayEWMA) update(delays []ProxyDelay, alpha float64) {
+	e.cycle++
+	for _, item := range delays {
+		entry, ok := e.entries[item.Name]
+		if !ok {
+			e.entries[item.Name] = &ewmaEntry{value: float64(item.DelayMS), lastSeen: e.cycle}
+			continue
+		}
+		entry.value = alpha*float64(item.DelayMS) + (1-alpha)*entry.value
+		entry.lastSeen = e.cycle
+	}
+	for name, entry := range e.entries {
+		if e.cycle-entry.lastSeen >= ewmaExpireCycles {
+			delete(e.entries, name)
+		}
+	}
+}
+
+// smooth returns delays with each known node's delay replaced by its
+// average, re-sorted fastest first.
+func (e *delayEWMA) smooth(delays []ProxyDelay) []ProxyDelay {
+	smoothed := make([]ProxyDelay, len(delays))
+	copy(smoothed, delays)
+	for i, item := range smoothed {
+		if entry, ok := e.entries[item.Name]; ok {
+			smoothed[i].DelayMS = int(math.Round(entry.value))
+		}
+	}
+	sortDelays(smoothed)
+	return smoothed
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDelayEWMAUpdateAndExpire(t *testing.T) {
+	averages := newDelayEWMA()
+	averages.update([]ProxyDelay{{Name: "A", DelayMS: 100}, {Name: "B", DelayMS: 400}}, 0.5)
+	averages.update([]ProxyDelay{{Name: "A", DelayMS: 300}}, 0.5)
+
+	smoothed := averages.smooth([]ProxyDelay{{Name: "A", DelayMS: 300}, {Name: "B", DelayMS: 50}, {Name: "C", DelayMS: 90}})
+	want := []ProxyDelay{{Name: "C", DelayMS: 90}, {Name: "A", DelayMS: 200}, {Name: "B", DelayMS: 400}}
+	for i := range want {
+		if smoothed[i] != want[i] {
+			t.Fatalf("unexpected smoothed delays %+v", smoothed)
+		}
+	}
+
+	for i := 0; i < ewmaExpireCycles-2; i++ {
+		averages.update([]ProxyDelay{{Name: "A", DelayMS: 200}}, 0.5)
+	}
+	if _, ok := averages.entries["B"]; !ok {
+		t.Fatal("B expired too early")
+	}
+	averages.update([]ProxyDelay{{Name: "A", DelayMS: 200}}, 0.5)
+	if _, ok := averages.entries["B"]; ok {
+		t.Fatalf("expected B to expire after %d unanswered cycles", ewmaExpireCycles)
+	}
+}
+
+func TestAutoSelectEWMAIgnoresSingleSpike(t *testing.T) {
+	fc := &fakeController{
+		now:         "US-01",
+		groupDelays: map[string]any{"US-01": 500, "JP-01": 300},
+	}
+	server := newFakeController(t, fc)
+	cfg := Config{
+		ControllerURL:        server.URL,
+		ProxyGroup:           "GLOBAL",
+		TestURL:              "https://example.com",
+		DelayTimeoutMS:       100,
+		AutoSelectDiffMS:     300,
+		KeepDelayThresholdMS: 1000,
+		DelayEWMAAlpha:       0.2,
+		ewma:                 newDelayEWMA(),
+	}
+
+	if result := autoSelectOnce(context.Background(), server.Client(), cfg, false, true); result["action"] != "kept" {
+		t.Fatalf("expected first cycle to keep, got %#v", result)
+	}
+	fc.groupDelays["US-01"] = 2000
+	result := autoSelectOnce(context.Background(), server.Client(), cfg, false, true)
+	if result["action"] != "kept" || *result["delay_ms"].(*int) != 800 {
+		t.Fatalf("expected the spike to be smoothed to 800ms and kept, got %#v", result)
+	}
+
+	cfg.ewma = nil
+	if result := autoSelectOnce(context.Background(), server.Client(), cfg, false, true); result["action"] != "would_switch" {
+		t.Fatalf("expected the raw spike to trigger a switch, got %#v", result)
+	}
+}
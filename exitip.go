@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const exitIPTimeout = 10 * time.Second
+
+// ExitIPInfo is what an IP-echo service reports for the proxy's egress.
+type ExitIPInfo struct {
+	IP          string `json:"ip"`
+	Country     string `json:"country,omitempty"`
+	CountryCode string `json:"country_code,omitempty"`
+}
+
+// nameRegions maps ISO country codes to the keywords providers put in node
+// names. Two-letter tokens only match as standalone words.
+var nameRegions = []struct {
+	code     string
+	keywords []string
+	tokenRE  *regexp.Regexp
+}{
+	{"HK", []string{"香港", "hong kong", "hongkong", "🇭🇰"}, hkTokenRE},
+	{"TW", []string{"台湾", "台灣", "taiwan", "🇹🇼"}, regionTokenRE("tw")},
+	{"JP", []string{"日本", "东京", "大阪", "japan", "tokyo", "osaka", "🇯🇵"}, regionTokenRE("jp")},
+	{"KR", []string{"韩国", "韓國", "首尔", "korea", "seoul", "🇰🇷"}, regionTokenRE("kr")},
+	{"SG", []string{"新加坡", "狮城", "singapore", "🇸🇬"}, regionTokenRE("sg")},
+	{"US", []string{"美国", "美國", "united states", "los angeles", "san jose", "🇺🇸"}, regionTokenRE("us|usa")},
+	{"GB", []string{"英国", "英國", "united kingdom", "london", "🇬🇧"}, regionTokenRE("uk|gb")},
+	{"DE", []string{"德国", "德國", "germany", "frankfurt", "🇩🇪"}, regionTokenRE("de")},
+}
+
+func regionTokenRE(tokens string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(^|[^a-z0-9])(` + tokens + `)([^a-z0-9]|$)`)
+}
+
+// inferRegion guesses the ISO country code a node name advertises, or "".
+func inferRegion(name string) string {
+	lowered := strings.ToLower(name)
+	for _, region := range nameRegions {
+		for _, keyword := range region.keywords {
+			if strings.Contains(lowered, keyword) {
+				return region.code
+			}
+		}
+		if region.tokenRE.MatchString(lowered) {
+			return region.code
+		}
+	}
+	return ""
+}
+
+// fetchExitIP asks the IP-echo service at EXIT_IP_URL, through the local
+// proxy, which address and country traffic leaves from.
+func fetchExitIP(cfg Config) (ExitIPInfo, error) {
+	transport, err := buildTransportForProxy(cfg.ProxyAddr)
+	if err != nil {
+		return ExitIPInfo{}, err
+	}
+	client := &http.Client{Transport: transport, Timeout: exitIPTimeout}
+	req, err := http.NewRequest(http.MethodGet, cfg.ExitIPURL, nil)
+	if err != nil {
+		return ExitIPInfo{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return ExitIPInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return ExitIPInfo{}, fmt.Errorf("exit IP service returned %s", resp.Status)
+	}
+
+	var payload map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, expectBodyReadLimit)).Decode(&payload); err != nil {
+		return ExitIPInfo{}, fmt.Errorf("exit IP service returned invalid JSON: %v", err)
+	}
+	info := ExitIPInfo{
+		IP:          firstStringField(payload, "ip", "query"),
+		Country:     firstStringField(payload, "country", "country_name"),
+		CountryCode: strings.ToUpper(firstStringField(payload, "country_iso", "country_code", "countryCode")),
+	}
+	if info.CountryCode == "" && len(info.Country) == 2 {
+		info.CountryCode = strings.ToUpper(info.Country)
+	}
+	if info.IP == "" {
+		return ExitIPInfo{}, fmt.Errorf("exit IP service response has no ip field")
+	}
+	return info, nil
+}
+
+func firstStringField(payload map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if v, ok := payload[key].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func printExitIPOnce(client *http.Client, cfg Config, jsonOutput bool) {
+	if strings.TrimSpace(cfg.ProxyAddr) == "" {
+		if jsonOutput {
+			printJSON(map[string]any{"error": "MIHOMO_PROXY_ADDR is empty"})
+		} else {
+			fmt.Println("MIHOMO_PROXY_ADDR is empty")
+		}
+		return
+	}
+
+	current, currentFound := getCurrentProxy(client, cfg)
+	info, err := fetchExitIP(cfg)
+	if err != nil {
+		if jsonOutput {
+			printJSON(map[string]any{"error": err.Error()})
+		} else {
+			fmt.Printf("Exit IP check failed: %v\n", err)
+		}
+		return
+	}
+
+	nameRegion := ""
+	if currentFound {
+		nameRegion = inferRegion(current)
+	}
+	mismatch := nameRegion != "" && info.CountryCode != "" && nameRegion != info.CountryCode
+	if mismatch {
+		logWarnf("Warning: node %q looks like %s but exits in %s", current, nameRegion, info.CountryCode)
+	}
+
+	if jsonOutput {
+		var regionValue any
+		if nameRegion != "" {
+			regionValue = nameRegion
+		}
+		printJSON(map[string]any{
+			"current":         current,
+			"current_found":   currentFound,
+			"ip":              info.IP,
+			"country":         info.Country,
+			"country_code":    info.CountryCode,
+			"name_region":     regionValue,
+			"region_mismatch": mismatch,
+		})
+		return
+	}
+
+	currentText := "unknown"
+	if currentFound {
+		currentText = sanitizeName(current)
+	}
+	location := info.Country
+	if info.CountryCode != "" && info.CountryCode != info.Country {
+		location = strings.TrimSpace(fmt.Sprintf("%s (%s)", info.Country, info.CountryCode))
+	}
+	status := "ok"
+	if mismatch {
+		status = "region-mismatch"
+	}
+	fmt.Printf("exit\t%s\t%s\t%s\t%s\n", info.IP, location, currentText, status)
+}
+
+const exitIPCacheTTL = time.Hour
+
+// exitIPCache remembers the exit IP observed for each proxy while it was
+// selected. Only the current proxy can be probed through the local proxy, so
+// candidates are known from earlier cycles.
+type exitIPCache struct {
+	mu      sync.Mutex
+	entries map[string]exitIPEntry
+}
+
+type exitIPEntry struct {
+	ip       string
+	observed time.Time
+}
+
+func newExitIPCache() *exitIPCache {
+	return &exitIPCache{entries: make(map[string]exitIPEntry)}
+}
+
+func (c *exitIPCache) store(name, ip string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[name] = exitIPEntry{ip: ip, observed: time.Now()}
+}
+
+func (c *exitIPCache) lookup(name string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[name]
+	if !ok || time.Since(entry.observed) > exitIPCacheTTL {
+		return "", false
+	}
+	return entry.ip, true
+}
+
+// checksExitIP reports whether AVOID_SAME_EXIT_IP applies. The cache only
+// lives for the process, so it is created for --monitor alone; one-shot runs
+// would never find a candidate in it and skip the probe.
+func checksExitIP(cfg Config) bool {
+	return cfg.AvoidSameExitIP && cfg.exitIPs != nil && strings.TrimSpace(cfg.ProxyAddr) != ""
+}
+
+// sharesExitIP probes the current proxy's exit IP, caches it, and reports
+// whether the candidate was last seen egressing from the same address.
+func sharesExitIP(cfg Config, current, candidate string) bool {
+	info, err := fetchExitIP(cfg)
+	if err != nil {
+		logWarnf("AVOID_SAME_EXIT_IP: exit IP check failed: %v", err)
+		return false
+	}
+	cfg.exitIPs.store(current, info.IP)
+	candidateIP, ok := cfg.exitIPs.lookup(candidate)
+	return ok && candidateIP == info.IP
+}
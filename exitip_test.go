@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestInferRegion(t *testing.T) {
+	cases := map[string]string{
+		"🇭🇰 香港 01":          "HK",
+		"HK-IPLC-02":        "HK",
+		"Japan Tokyo 03":    "JP",
+		"日本-大阪":             "JP",
+		"US_LosAngeles":     "US",
+		"Singapore Premium": "SG",
+		"UK London":         "GB",
+		"Plus Node 1":       "",
+		"Auto":              "",
+	}
+	for name, want := range cases {
+		if got := inferRegion(name); got != want {
+			t.Fatalf("inferRegion(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPrintExitIPReportsRegionMismatch(t *testing.T) {
+	server := newFakeController(t, &fakeController{now: "HK-01"})
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"ip": "203.0.113.7", "country": "Japan", "country_iso": "JP"})
+	}))
+	t.Cleanup(echo.Close)
+
+	cfg := Config{
+		ControllerURL: server.URL,
+		ProxyGroup:    "PROXY",
+		ProxyAddr:     echo.URL,
+		ExitIPURL:     "http://ip.example/json",
+	}
+	payload := decodeJSONOutput(t, captureStdout(t, func() {
+		printExitIPOnce(server.Client(), cfg, true)
+	}))
+	if payload["ip"] != "203.0.113.7" || payload["country_code"] != "JP" || payload["name_region"] != "HK" || payload["region_mismatch"] != true {
+		t.Fatalf("unexpected exit IP payload: %#v", payload)
+	}
+
+	out := string(captureStdout(t, func() {
+		printExitIPOnce(server.Client(), cfg, false)
+	}))
+	if out != "exit\t203.0.113.7\tJapan (JP)\tHK-01\tregion-mismatch\n" {
+		t.Fatalf("unexpected text output: %q", out)
+	}
+}
+
+func TestAutoSelectAvoidsSameExitIP(t *testing.T) {
+	fc := &fakeController{
+		now:         "A",
+		groupDelays: map[string]any{"A": 2500, "B": 100},
+	}
+	server := newFakeController(t, fc)
+	var probes atomic.Int32
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{"ip": "198.51.100.1"})
+	}))
+	t.Cleanup(echo.Close)
+
+	cfg := Config{
+		ControllerURL:        server.URL,
+		ProxyGroup:           "PROXY",
+		TestURL:              "https://example.com",
+		DelayTimeoutMS:       3000,
+		AutoSelectDiffMS:     300,
+		KeepDelayThresholdMS: 2000,
+		ProxyAddr:            echo.URL,
+		ExitIPURL:            "http://ip.example/json",
+		AvoidSameExitIP:      true,
+	}
+
+	// Without the --monitor cache the check is skipped and nothing is probed.
+	payload := decodeJSONOutput(t, captureStdout(t, func() {
+		autoSelectOnce(context.Background(), server.Client(), cfg, true, true)
+	}))
+	if payload["action"] != "would_switch" || probes.Load() != 0 {
+		t.Fatalf("expected no exit IP probe outside --monitor, got %#v after %d probes", payload, probes.Load())
+	}
+
+	cfg.exitIPs = newExitIPCache()
+
+	payload = decodeJSONOutput(t, captureStdout(t, func() {
+		autoSelectOnce(context.Background(), server.Client(), cfg, true, true)
+	}))
+	if payload["action"] != "would_switch" {
+		t.Fatalf("expected switch while candidate exit IP is unknown, got %#v", payload)
+	}
+
+	cfg.exitIPs.store("B", "198.51.100.1")
+	payload = decodeJSONOutput(t, captureStdout(t, func() {
+		autoSelectOnce(context.Background(), server.Client(), cfg, true, true)
+	}))
+	if payload["action"] != "kept" || payload["reason"] != "candidate shares exit IP with current" {
+		t.Fatalf("expected shared exit IP to block switch, got %#v", payload)
+	}
+	if ip, ok := cfg.exitIPs.lookup("A"); !ok || ip != "198.51.100.1" {
+		t.Fatalf("expected current exit IP cached, got %q %v", ip, ok)
+	}
+}
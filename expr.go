@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// scoreExprVars are the variables SCORE_EXPR may reference.
+var scoreExprVars = []string{"delay", "loss", "endpoint_latency"}
+
+// scoreExpr is a compiled SCORE_EXPR: arithmetic over numbers and the
+// variables in scoreExprVars with + - * /, parentheses and min/max/abs.
+type scoreExpr struct {
+	root exprNode
+}
+
+type exprNode interface {
+	eval(vars map[string]float64) float64
+}
+
+type exprNum float64
+
+type exprVar string
+
+type exprUnary struct {
+	x exprNode
+}
+
+type exprBinary struct {
+	op   byte
+	x, y exprNode
+}
+
+type exprCall struct {
+	name string
+	args []exprNode
+}
+
+func (n exprNum) eval(map[string]float64) float64 { return float64(n) }
+
+func (n exprVar) eval(vars map[string]float64) float64 { return vars[string(n)] }
+
+func (n exprUnary) eval(vars map[string]float64) float64 { return -n.x.eval(vars) }
+
+func (n exprBinary) eval(vars map[string]float64) float64 {
+	x, y := n.x.eval(vars), n.y.eval(vars)
+	switch n.op {
+	case '+':
+		return x + y
+	case '-':
+		return x - y
+	case '*':
+		return x * y
+	default:
+		return x / y
+	}
+}
+
+func (n exprCall) eval(vars map[string]float64) float64 {
+	switch n.name {
+	case "abs":
+		return math.Abs(n.args[0].eval(vars))
+	case "min":
+		return math.Min(n.args[0].eval(vars), n.args[1].eval(vars))
+	default:
+		return math.Max(n.args[0].eval(vars), n.args[1].eval(vars))
+	}
+}
+
+var exprFuncArity = map[string]int{"abs": 1, "min": 2, "max": 2}
+
+// compileScoreExpr parses src, rejecting unknown variables and functions.
+func compileScoreExpr(src string) (*scoreExpr, error) {
+	p := &exprParser{src: src}
+	p.next()
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.tok, p.tokPos)
+	}
+	return &scoreExpr{root: root}, nil
+}
+
+func (e *scoreExpr) eval(vars map[string]float64) float64 {
+	return e.root.eval(vars)
+}
+
+// uses reports whether the expression references the named variable.
+func (e *scoreExpr) uses(name string) bool {
+	return exprUses(e.root, name)
+}
+
+func exprUses(n exprNode, name string) bool {
+	switch n := n.(type) {
+	case exprVar:
+		return string(n) == name
+	case exprUnary:
+		return exprUses(n.x, name)
+	case exprBinary:
+		return exprUses(n.x, name) || exprUses(n.y, name)
+	case exprCall:
+		for _, arg := range n.args {
+			if exprUses(arg, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type exprParser struct {
+	src    string
+	pos    int
+	tok    string
+	tokPos int
+}
+
+// next advances to the next token: a number, an identifier, or a single
+// punctuation character. tok is empty at end of input.
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	p.tokPos = p.pos
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return
+	}
+	start := p.pos
+	c := rune(p.src[p.pos])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+	case unicode.IsLetter(c) || c == '_':
+		for p.pos < len(p.src) && (unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '_') {
+			p.pos++
+		}
+	default:
+		p.pos++
+	}
+	p.tok = p.src[start:p.pos]
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	x, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok[0]
+		p.next()
+		y, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		x = exprBinary{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "*" || p.tok == "/" {
+		op := p.tok[0]
+		p.next()
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = exprBinary{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.tok == "-" {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprUnary{x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok, pos := p.tok, p.tokPos
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case tok == "(":
+		p.next()
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("missing ) at offset %d", p.tokPos)
+		}
+		p.next()
+		return x, nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", tok, pos)
+		}
+		p.next()
+		return exprNum(v), nil
+	case unicode.IsLetter(rune(tok[0])) || tok[0] == '_':
+		p.next()
+		if p.tok != "(" {
+			for _, name := range scoreExprVars {
+				if name == tok {
+					return exprVar(tok), nil
+				}
+			}
+			return nil, fmt.Errorf("unknown variable %q (available: %s)", tok, strings.Join(scoreExprVars, ", "))
+		}
+		arity, ok := exprFuncArity[tok]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %q (available: abs, min, max)", tok)
+		}
+		p.next()
+		args := make([]exprNode, 0, arity)
+		for p.tok != ")" {
+			if len(args) > 0 {
+				if p.tok != "," {
+					return nil, fmt.Errorf("expected , or ) at offset %d", p.tokPos)
+				}
+				p.next()
+			}
+			arg, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		p.next()
+		if len(args) != arity {
+			return nil, fmt.Errorf("%s takes %d argument(s), got %d", tok, arity, len(args))
+		}
+		return exprCall{name: tok, args: args}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok, pos)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestScoreExprEval(t *testing.T) {
+	vars := map[string]float64{"delay": 200, "loss": 0.5, "endpoint_latency": 80}
+	cases := []struct {
+		src  string
+		want float64
+	}{
+		{"delay", 200},
+		{"delay + loss * 1000", 700},
+		{"(delay + endpoint_latency) / 2", 140},
+		{"-delay + 2 * 3", -194},
+		{"max(delay, endpoint_latency * 3) + min(1, loss)", 240.5},
+		{"abs(endpoint_latency - delay)", 120},
+		{" delay\t* 1.5 ", 300},
+	}
+	for _, tc := range cases {
+		expr, err := compileScoreExpr(tc.src)
+		if err != nil {
+			t.Fatalf("compile %q failed: %v", tc.src, err)
+		}
+		if got := expr.eval(vars); got != tc.want {
+			t.Fatalf("eval %q = %v, want %v", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestScoreExprRejectsInvalid(t *testing.T) {
+	cases := map[string]string{
+		"delay +":         "unexpected end",
+		"jitter * 2":      "unknown variable",
+		"exec(delay)":     "unknown function",
+		"max(delay)":      "takes 2 argument",
+		"(delay + 1":      "missing )",
+		"delay delay":     "unexpected",
+		"1..2":            "invalid number",
+		"delay; rm -rf /": "unexpected",
+	}
+	for src, want := range cases {
+		if _, err := compileScoreExpr(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("compile %q: expected error containing %q, got %v", src, want, err)
+		}
+	}
+}
+
+func TestScoreCandidatesOrdersByScore(t *testing.T) {
+	expr, err := compileScoreExpr("delay + loss * 1000")
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	delays := []ProxyDelay{
+		{Name: "Cur", DelayMS: 50},
+		{Name: "Flaky", DelayMS: 80, Loss: 0.5},
+		{Name: "Steady", DelayMS: 200},
+		{Name: "Slow", DelayMS: 400},
+	}
+	got := scoreCandidates(context.Background(), nil, Config{ScoreExpr: expr}, delays, "Cur")
+	names := make([]string, 0, len(got))
+	for _, item := range got {
+		names = append(names, item.Name)
+	}
+	if strings.Join(names, ",") != "Steady,Slow,Flaky" {
+		t.Fatalf("unexpected order: %v", names)
+	}
+}
+
+func TestLoadConfigEndpointWeight(t *testing.T) {
+	cfg, err := loadConfigInTempDir(t, map[string]string{"ENDPOINT_WEIGHT": "0.5"})
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.ScoreExpr != nil {
+		t.Fatalf("ENDPOINT_WEIGHT without ENDPOINT_URLS should keep delay-only ranking")
+	}
+
+	t.Setenv("ENDPOINT_URLS", "https://e1.example")
+	cfg, err = loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.ScoreExpr == nil || cfg.ScoreExpr.eval(map[string]float64{"delay": 100, "endpoint_latency": 400}) != 300 {
+		t.Fatalf("expected delay + 0.5 * endpoint_latency, got %v", cfg.ScoreExpr)
+	}
+
+	fc := &fakeController{proxyDelays: map[string]int{
+		"B|https://e1.example": 900,
+		"C|https://e1.example": 80,
+	}}
+	server := newFakeController(t, fc)
+	cfg.ControllerURL = server.URL
+	cfg.DelayTimeoutMS = 1000
+	ranked := scoreCandidates(context.Background(), server.Client(), cfg, []ProxyDelay{{Name: "A", DelayMS: 2500}, {Name: "B", DelayMS: 100}, {Name: "C", DelayMS: 300}}, "A")
+	if len(ranked) != 2 || ranked[0].Name != "C" {
+		t.Fatalf("expected C (300 + 40) ahead of B (100 + 450), got %v", ranked)
+	}
+
+	for _, raw := range []string{"-1", "NaN", "heavy"} {
+		t.Setenv("ENDPOINT_WEIGHT", raw)
+		if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "ENDPOINT_WEIGHT") {
+			t.Fatalf("expected ENDPOINT_WEIGHT error for %q, got %v", raw, err)
+		}
+	}
+	t.Setenv("ENDPOINT_WEIGHT", "1")
+	t.Setenv("SCORE_EXPR", "delay")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "SCORE_EXPR") {
+		t.Fatalf("expected ENDPOINT_WEIGHT and SCORE_EXPR to conflict, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// grafanaRow is one data point of --format grafana output, a flat shape the
+// Grafana JSON/Infinity datasources read without transforms.
+type grafanaRow struct {
+	Metric string  `json:"metric"`
+	Value  float64 `json:"value"`
+	Time   string  `json:"time"`
+}
+
+func printGrafanaRows(rows []grafanaRow) {
+	if rows == nil {
+		rows = []grafanaRow{}
+	}
+	fmt.Println(mustASCIIJSON(rows))
+}
+
+// statsGrafanaRows turns --stats figures into rows named after their JSON
+// keys; figures the controller did not report are left out.
+func statsGrafanaRows(stats map[string]any, now time.Time) []grafanaRow {
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	rows := make([]grafanaRow, 0, len(keys))
+	for _, key := range keys {
+		if value, ok := stats[key].(int); ok {
+			rows = append(rows, grafanaRow{Metric: key, Value: float64(value), Time: now.UTC().Format(time.RFC3339)})
+		}
+	}
+	return rows
+}
+
+// histogramGrafanaRows reports each delay bucket count as delay_bucket_<label>.
+func histogramGrafanaRows(buckets []DelayBucket, now time.Time) []grafanaRow {
+	rows := make([]grafanaRow, 0, len(buckets))
+	for _, bucket := range buckets {
+		rows = append(rows, grafanaRow{Metric: "delay_bucket_" + bucket.Label, Value: float64(bucket.Count), Time: now.UTC().Format(time.RFC3339)})
+	}
+	return rows
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrafanaRows(t *testing.T) {
+	now := time.Date(2025, 1, 1, 8, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	rows := statsGrafanaRows(map[string]any{"up_bps": 2048, "down_bps": 4096, "note": "skip"}, now)
+	if len(rows) != 2 || rows[0].Metric != "down_bps" || rows[0].Value != 4096 || rows[1].Metric != "up_bps" {
+		t.Fatalf("unexpected stats rows: %+v", rows)
+	}
+	if rows[0].Time != "2025-01-01T00:00:00Z" {
+		t.Fatalf("time should be RFC3339 UTC, got %q", rows[0].Time)
+	}
+
+	rows = histogramGrafanaRows([]DelayBucket{{Label: "<100ms", Count: 3}, {Label: "timeout", Count: 1}}, now)
+	if len(rows) != 2 || rows[0].Metric != "delay_bucket_<100ms" || rows[0].Value != 3 || rows[1].Value != 1 {
+		t.Fatalf("unexpected histogram rows: %+v", rows)
+	}
+
+	out := captureStdout(t, func() { printGrafanaRows(nil) })
+	var decoded []map[string]any
+	if err := json.Unmarshal(out, &decoded); err != nil || decoded == nil || len(decoded) != 0 {
+		t.Fatalf("empty rows should print [], got %q err=%v", out, err)
+	}
+}
+
+func TestParseArgsFormatValidation(t *testing.T) {
+	if args, err := parseArgsFrom([]string{"--stats", "--format", "grafana"}); err != nil || args.Format != "grafana" {
+		t.Fatalf("unexpected result %+v err=%v", args, err)
+	}
+	if _, err := parseArgsFrom([]string{"--print-delays", "--histogram", "--format", "grafana"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := parseArgsFrom([]string{"--stats", "--format", "csv"}); err == nil || !strings.Contains(err.Error(), "--format must be grafana") {
+		t.Fatalf("expected format value error, got %v", err)
+	}
+	if _, err := parseArgsFrom([]string{"--print-delays", "--format", "grafana"}); err == nil || !strings.Contains(err.Error(), "--format can only be used") {
+		t.Fatalf("expected format usage error, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// proxyGroups lists the MIHOMO_PROXY_GROUP entries. Configs not built by
+// loadConfig only set ProxyGroup.
+func proxyGroups(cfg Config) []string {
+	if len(cfg.ProxyGroups) == 0 {
+		return []string{cfg.ProxyGroup}
+	}
+	return cfg.ProxyGroups
+}
+
+// autoSelectGroups runs autoSelectOnce for each group in turn and returns
+// the results in group order. With a single group it behaves exactly like
+// autoSelectOnce; with several, text output is preceded by a group line per
+// group and JSON output is one array whose results carry their group.
+func autoSelectGroups(client *http.Client, cfg Config, jsonOutput, dryRun bool) []map[string]any {
+	groups := proxyGroups(cfg)
+	if len(groups) == 1 {
+		return []map[string]any{autoSelectOnce(context.Background(), client, cfg, jsonOutput, dryRun)}
+	}
+	results := make([]map[string]any, 0, len(groups))
+	for _, group := range groups {
+		groupCfg := cfg
+		groupCfg.ProxyGroup = group
+		groupCfg.collectOnly = jsonOutput
+		if !jsonOutput {
+			fmt.Printf("group\t%s\n", sanitizeName(group))
+		}
+		result := autoSelectOnce(context.Background(), client, groupCfg, jsonOutput, dryRun)
+		result["group"] = group
+		result["schema_version"] = jsonSchemaVersion
+		results = append(results, result)
+	}
+	if jsonOutput {
+		fmt.Println(mustASCIIJSON(results))
+	}
+	return results
+}
+
+// groupsExitCode combines per-group --quiet exit codes: 1 if any group
+// failed, else 3 if any switched, else 0.
+func groupsExitCode(results []map[string]any) int {
+	code := 0
+	for _, result := range results {
+		switch decisionExitCode(result) {
+		case 1:
+			return 1
+		case 3:
+			code = 3
+		}
+	}
+	return code
+}
+
+// printDelaysGroups is --print-delays for several groups. JSON output is an
+// array of {"group","delays"} objects; a group without delays adds the
+// no-data fields.
+func printDelaysGroups(client *http.Client, cfg Config, jsonOutput bool) {
+	payload := make([]map[string]any, 0, len(cfg.ProxyGroups))
+	for _, group := range proxyGroups(cfg) {
+		groupCfg := cfg
+		groupCfg.ProxyGroup = group
+		if !jsonOutput {
+			fmt.Printf("group\t%s\n", sanitizeName(group))
+			printDelaysOnce(client, groupCfg, false)
+			continue
+		}
+		delays, reason := topGroupDelays(client, groupCfg)
+		entry := map[string]any{"group": group, "delays": []any{}, "schema_version": jsonSchemaVersion}
+		if len(delays) == 0 {
+			for key, value := range noDataResult(reason) {
+				entry[key] = value
+			}
+		} else {
+			entry["delays"] = delaysPayload(groupCfg, delays, reason)
+		}
+		payload = append(payload, entry)
+	}
+	if jsonOutput {
+		fmt.Println(mustASCIIJSON(payload))
+	}
+}
+
+// checkMonitorGroups rejects several MIHOMO_PROXY_GROUP entries for
+// --monitor, which keeps its per-cycle state for a single group.
+func checkMonitorGroups(cfg Config) error {
+	if groups := proxyGroups(cfg); len(groups) > 1 {
+		return fmt.Errorf("--monitor supports a single MIHOMO_PROXY_GROUP, got %s; run one monitor per group", strings.Join(groups, ","))
+	}
+	return nil
+}
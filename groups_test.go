@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newMultiGroupController serves two selector groups: Streaming is on a slow
+// node with a faster alternative, Chat is on a fast node.
+func newMultiGroupController(t *testing.T) *httptest.Server {
+	t.Helper()
+	now := map[string]string{"Streaming": "US-01", "Chat": "JP-01"}
+	delays := map[string]map[string]any{
+		"Streaming": {"US-01": 2500, "SG-01": 150},
+		"Chat":      {"JP-01": 90, "SG-01": 150},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch {
+		case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "proxies":
+			_ = json.NewEncoder(w).Encode(map[string]any{"now": now[parts[1]]})
+		case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "group":
+			_ = json.NewEncoder(w).Encode(map[string]any{"delays": delays[parts[1]]})
+		case r.Method == http.MethodPut:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func multiGroupConfig(url string) Config {
+	return Config{
+		ControllerURL:        url,
+		ProxyGroup:           "Streaming",
+		ProxyGroups:          []string{"Streaming", "Chat"},
+		TestURL:              "https://example.com",
+		DelayTimeoutMS:       100,
+		AutoSelectDiffMS:     300,
+		KeepDelayThresholdMS: 2000,
+	}
+}
+
+func TestAutoSelectGroupsJSON(t *testing.T) {
+	server := newMultiGroupController(t)
+	cfg := multiGroupConfig(server.URL)
+
+	var results []map[string]any
+	raw := captureStdout(t, func() { results = autoSelectGroups(server.Client(), cfg, true, false) })
+	var printed []map[string]any
+	if err := json.Unmarshal(raw, &printed); err != nil {
+		t.Fatalf("expected one JSON array, got %q: %v", raw, err)
+	}
+	if len(printed) != 2 || printed[0]["group"] != "Streaming" || printed[0]["action"] != "switched" || printed[0]["to"] != "SG-01" ||
+		printed[1]["group"] != "Chat" || printed[1]["action"] != "kept" || printed[1]["schema_version"] != float64(jsonSchemaVersion) {
+		t.Fatalf("unexpected per-group results: %s", raw)
+	}
+	if len(results) != 2 || groupsExitCode(results) != 3 {
+		t.Fatalf("expected two results combining to exit code 3, got %v", results)
+	}
+	if code := groupsExitCode([]map[string]any{{"action": "switched"}, {"error": "no delay data"}}); code != 1 {
+		t.Fatalf("expected a failed group to win, got %d", code)
+	}
+}
+
+func TestAutoSelectGroupsSingleGroupUnchanged(t *testing.T) {
+	server := newMultiGroupController(t)
+	cfg := multiGroupConfig(server.URL)
+	cfg.ProxyGroup, cfg.ProxyGroups = "Chat", []string{"Chat"}
+
+	raw := captureStdout(t, func() { autoSelectGroups(server.Client(), cfg, true, false) })
+	var printed map[string]any
+	if err := json.Unmarshal(raw, &printed); err != nil {
+		t.Fatalf("expected a single JSON object, got %q: %v", raw, err)
+	}
+	if _, ok := printed["group"]; ok || printed["action"] != "kept" {
+		t.Fatalf("single group output changed: %s", raw)
+	}
+}
+
+func TestPrintDelaysGroups(t *testing.T) {
+	server := newMultiGroupController(t)
+	cfg := multiGroupConfig(server.URL)
+	cfg.ProxyGroups = append(cfg.ProxyGroups, "Empty")
+
+	raw := captureStdout(t, func() { printDelaysGroups(server.Client(), cfg, true) })
+	var printed []map[string]any
+	if err := json.Unmarshal(raw, &printed); err != nil {
+		t.Fatalf("expected one JSON array, got %q: %v", raw, err)
+	}
+	if len(printed) != 3 || printed[0]["group"] != "Streaming" || printed[1]["group"] != "Chat" {
+		t.Fatalf("unexpected groups: %s", raw)
+	}
+	first := printed[1]["delays"].([]any)[0].(map[string]any)
+	if first["name"] != "JP-01" || first["delay_ms"] != float64(90) {
+		t.Fatalf("unexpected Chat delays: %v", printed[1]["delays"])
+	}
+	if printed[2]["reason_code"] != noDataGroupEmpty || len(printed[2]["delays"].([]any)) != 0 {
+		t.Fatalf("expected empty group to report no data, got %v", printed[2])
+	}
+
+	text := string(captureStdout(t, func() { printDelaysGroups(server.Client(), cfg, false) }))
+	if !strings.HasPrefix(text, "group\tStreaming\ntest_url\thttps://example.com\n") || !strings.Contains(text, "group\tChat\n") {
+		t.Fatalf("unexpected text output:\n%s", text)
+	}
+}
+
+func TestLoadConfigProxyGroupList(t *testing.T) {
+	cfg, err := loadConfigInTempDir(t, map[string]string{"MIHOMO_PROXY_GROUP": "Streaming, Chat"})
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.ProxyGroup != "Streaming" || strings.Join(cfg.ProxyGroups, "|") != "Streaming|Chat" {
+		t.Fatalf("unexpected groups %q %q", cfg.ProxyGroup, cfg.ProxyGroups)
+	}
+}
+
+func TestCheckMonitorGroups(t *testing.T) {
+	if err := checkMonitorGroups(Config{ProxyGroup: "PROXY"}); err != nil {
+		t.Fatalf("expected a single group to pass, got %v", err)
+	}
+	if err := checkMonitorGroups(Config{ProxyGroup: "A", ProxyGroups: []string{"A", "B"}}); err == nil {
+		t.Fatalf("expected several groups to be rejected for --monitor")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const healthShutdownTimeout = 5 * time.Second
+
+// healthCheck reports whether the controller answers and the current node's
+// delay is within KEEP_DELAY_THRESHOLD_MS. Only the current node is probed,
+// through the per-proxy delay API, so frequent probes do not load the group.
+func healthCheck(client *http.Client, cfg Config) (bool, map[string]any) {
+	report := map[string]any{
+		"healthy":      false,
+		"proxy_group":  cfg.ProxyGroup,
+		"threshold_ms": cfg.KeepDelayThresholdMS,
+	}
+	info, err := getGroupInfo(context.Background(), client, cfg)
+	if err != nil {
+		report["reason"] = fmt.Sprintf("controller unreachable: %v", err)
+		return false, report
+	}
+	if info.Now == "" {
+		report["reason"] = "current proxy not found"
+		return false, report
+	}
+	report["current"] = info.Now
+	delayMS, ok := getProxyDelay(context.Background(), client, cfg, info.Now, cfg.TestURL, cfg.DelayTimeoutMS)
+	if !ok {
+		report["delay_ms"] = nil
+		report["reason"] = "current proxy delay unavailable"
+		return false, report
+	}
+	report["delay_ms"] = delayMS
+	if delayMS > cfg.KeepDelayThresholdMS {
+		report["reason"] = fmt.Sprintf("delay %dms > %dms threshold", delayMS, cfg.KeepDelayThresholdMS)
+		return false, report
+	}
+	report["healthy"] = true
+	return true, report
+}
+
+func healthHandler(client *http.Client, cfg Config) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		healthy, report := healthCheck(client, cfg)
+		report["schema_version"] = jsonSchemaVersion
+		w.Header().Set("Content-Type", "application/json")
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintln(w, mustASCIIJSON(report))
+	})
+	return mux
+}
+
+// serveHealthOnce is --serve-health: it serves /healthz on HEALTH_ADDR until
+// a value arrives on stop, and returns the exit code. Like METRICS_ADDR, a
+// bare ":port" listens on all interfaces so an orchestrator can reach it.
+func serveHealthOnce(client *http.Client, cfg Config, stop <-chan os.Signal) int {
+	if cfg.HealthAddr == "" {
+		fmt.Fprintln(os.Stderr, "HEALTH_ADDR is required for --serve-health")
+		return 1
+	}
+	listener, err := net.Listen("tcp", cfg.HealthAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "HEALTH_ADDR: %v\n", err)
+		return 1
+	}
+	server := &http.Server{Handler: healthHandler(client, cfg), ReadHeaderTimeout: 5 * time.Second}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	logInfof("Health endpoint listening on http://%s/healthz", listener.Addr())
+
+	select {
+	case <-stop:
+		logInfof("Shutdown signal received")
+	case err := <-served:
+		logErrorf("Health server stopped: %v", err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logWarnf("Health server shutdown: %v", err)
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	fc := &fakeController{
+		now:         "JP-01",
+		proxyDelays: map[string]int{"JP-01|https://example.com": 150},
+	}
+	server := newFakeController(t, fc)
+	cfg := Config{
+		ControllerURL:        server.URL,
+		ProxyGroup:           "GLOBAL",
+		TestURL:              "https://example.com",
+		DelayTimeoutMS:       100,
+		KeepDelayThresholdMS: 2000,
+	}
+	get := func(cfg Config) (int, map[string]any) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		healthHandler(server.Client(), cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode %q: %v", rec.Body.String(), err)
+		}
+		return rec.Code, body
+	}
+
+	if code, body := get(cfg); code != http.StatusOK || body["healthy"] != true || body["current"] != "JP-01" || body["delay_ms"] != float64(150) ||
+		body["schema_version"] != float64(jsonSchemaVersion) {
+		t.Fatalf("expected healthy, got %d %v", code, body)
+	}
+
+	fc.proxyDelays["JP-01|https://example.com"] = 2500
+	if code, body := get(cfg); code != http.StatusServiceUnavailable || !strings.Contains(body["reason"].(string), "2500ms > 2000ms") {
+		t.Fatalf("expected slow node to be unhealthy, got %d %v", code, body)
+	}
+
+	fc.proxyDelays["JP-01|https://example.com"] = -1
+	if code, body := get(cfg); code != http.StatusServiceUnavailable || body["reason"] != "current proxy delay unavailable" {
+		t.Fatalf("expected timeout to be unhealthy, got %d %v", code, body)
+	}
+
+	cfg.ControllerURL = "http://127.0.0.1:1"
+	if code, body := get(cfg); code != http.StatusServiceUnavailable || !strings.HasPrefix(body["reason"].(string), "controller unreachable") {
+		t.Fatalf("expected unreachable controller to be unhealthy, got %d %v", code, body)
+	}
+
+	rec := httptest.NewRecorder()
+	healthHandler(server.Client(), cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/healthz", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for POST, got %d", rec.Code)
+	}
+}
+
+func TestServeHealthOnceStops(t *testing.T) {
+	if code := serveHealthOnce(http.DefaultClient, Config{}, nil); code != 1 {
+		t.Fatalf("expected exit 1 without HEALTH_ADDR, got %d", code)
+	}
+	stop := make(chan os.Signal, 1)
+	stop <- os.Interrupt
+	if code := serveHealthOnce(http.DefaultClient, Config{HealthAddr: "127.0.0.1:0"}, stop); code != 0 {
+		t.Fatalf("expected clean shutdown, got %d", code)
+	}
+}
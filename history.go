@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const historyTailPoll = 500 * time.Millisecond
+
+// historyMu serializes HISTORY_FILE appends so lines never interleave.
+var historyMu sync.Mutex
+
+// recordHistory appends a decision to HISTORY_FILE when one is set. With
+// several MIHOMO_PROXY_GROUP entries the line carries its group.
+func recordHistory(cfg Config, result map[string]any) {
+	if cfg.HistoryFile == "" {
+		return
+	}
+	if len(proxyGroups(cfg)) > 1 {
+		result = withGroup(result, cfg.ProxyGroup)
+	}
+	appendHistory(cfg.HistoryFile, result, time.Now())
+}
+
+func withGroup(result map[string]any, group string) map[string]any {
+	tagged := make(map[string]any, len(result)+1)
+	for key, value := range result {
+		tagged[key] = value
+	}
+	tagged["group"] = group
+	return tagged
+}
+
+// appendHistory appends result to the HISTORY_FILE at path as one JSON line,
+// stamped with now. Failures are logged; they never affect the run.
+func appendHistory(path string, result map[string]any, now time.Time) {
+	event := make(map[string]any, len(result)+1)
+	for key, value := range result {
+		event[key] = value
+	}
+	event["timestamp"] = now.UTC().Format(time.RFC3339)
+	line := mustASCIIJSON(event) + "\n"
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		logWarnf("Warning: history file unavailable: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.WriteString(line); err != nil {
+		logWarnf("Warning: history file write failed: %v", err)
+	}
+}
+
+// formatHistoryEvent renders one HISTORY_FILE line in the same layout as the
+// auto-select text output, prefixed with the event timestamp.
+func formatHistoryEvent(line []byte, cfg Config) (string, error) {
+	var event map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&event); err != nil {
+		return "", err
+	}
+	timestamp, _ := event["timestamp"].(string)
+	action, _ := event["action"].(string)
+	reason, _ := event["reason"].(string)
+	if action == "" {
+		action = "error"
+	}
+	if errText, ok := event["error"].(string); ok && reason == "" {
+		reason = errText
+	}
+
+	switch action {
+	case "switched", "would_switch", "switch_failed":
+		from, _ := event["from"].(string)
+		to, _ := event["to"].(string)
+		return fmt.Sprintf("%s\t%s\t%s\t%s -> %s\t%s\t(%s)", timestamp, action, sanitizeName(from),
+			historyDelay(event["from_delay_ms"], cfg), historyDelay(event["to_delay_ms"], cfg), sanitizeName(to), reason), nil
+	case "kept":
+		current, _ := event["current"].(string)
+		return fmt.Sprintf("%s\tkept\t%s\t%s\t(%s)", timestamp, historyDelay(event["delay_ms"], cfg), sanitizeName(current), reason), nil
+	}
+	return fmt.Sprintf("%s\t%s\t(%s)", timestamp, action, reason), nil
+}
+
+func historyDelay(value any, cfg Config) string {
+	if delayMS, ok := toInt(value); ok {
+		return formatDelay(delayMS, cfg)
+	}
+	return "nil"
+}
+
+// historyTailer follows a JSON lines file like tail -F: it starts at the end
+// of the file, re-reads from the start after truncation, and switches to the
+// new file after the path is rotated.
+type historyTailer struct {
+	path    string
+	file    *os.File
+	reader  *bufio.Reader
+	offset  int64
+	partial []byte
+}
+
+func (t *historyTailer) open(atEnd bool) error {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	t.offset = 0
+	if atEnd {
+		t.offset, err = file.Seek(0, io.SeekEnd)
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	t.file = file
+	t.reader = bufio.NewReader(file)
+	t.partial = nil
+	return nil
+}
+
+func (t *historyTailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+// poll passes every complete line appended since the last call to emit.
+func (t *historyTailer) poll(emit func([]byte)) error {
+	if t.file == nil {
+		if err := t.open(false); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	info, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < t.offset {
+		logInfof("History file %s truncated, reading from the start", t.path)
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		t.offset = 0
+		t.reader.Reset(t.file)
+		t.partial = nil
+	}
+	if err := t.drain(emit); err != nil {
+		return err
+	}
+
+	pathInfo, err := os.Stat(t.path)
+	if err != nil || os.SameFile(info, pathInfo) {
+		return nil
+	}
+	logInfof("History file %s rotated, following the new file", t.path)
+	t.close()
+	if err := t.open(false); err != nil {
+		return err
+	}
+	return t.drain(emit)
+}
+
+func (t *historyTailer) drain(emit func([]byte)) error {
+	for {
+		chunk, err := t.reader.ReadBytes('\n')
+		t.offset += int64(len(chunk))
+		t.partial = append(t.partial, chunk...)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line := bytes.TrimSpace(t.partial)
+		t.partial = nil
+		if len(line) > 0 {
+			emit(line)
+		}
+	}
+}
+
+// tailHistory follows HISTORY_FILE until stop fires, printing each new event.
+// With jsonOutput the raw lines are passed through unchanged.
+func tailHistory(cfg Config, out io.Writer, jsonOutput bool, stop <-chan os.Signal) error {
+	tailer := &historyTailer{path: cfg.HistoryFile}
+	if err := tailer.open(true); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	defer tailer.close()
+
+	emit := func(line []byte) {
+		if jsonOutput {
+			fmt.Fprintln(out, string(line))
+			return
+		}
+		text, err := formatHistoryEvent(line, cfg)
+		if err != nil {
+			logWarnf("Skipping malformed history line: %v", err)
+			return
+		}
+		fmt.Fprintln(out, text)
+	}
+
+	ticker := time.NewTicker(historyTailPoll)
+	defer ticker.Stop()
+	for {
+		if err := tailer.poll(emit); err != nil {
+			logWarnf("History file read failed: %v", err)
+			tailer.close()
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
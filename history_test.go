@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFormatHistoryEvent(t *testing.T) {
+	cases := []struct {
+		line string
+		want string
+	}{
+		{
+			line: `{"timestamp":"2025-01-01T00:00:00Z","action":"switched","from":"JP-1","to":"SG-1","from_delay_ms":900,"to_delay_ms":120,"reason":"faster"}`,
+			want: "2025-01-01T00:00:00Z\tswitched\tJP-1\t900ms -> 120ms\tSG-1\t(faster)",
+		},
+		{
+			line: `{"timestamp":"2025-01-01T00:05:00Z","action":"kept","current":"SG-1","delay_ms":null,"reason":"current delay unavailable"}`,
+			want: "2025-01-01T00:05:00Z\tkept\tnil\tSG-1\t(current delay unavailable)",
+		},
+		{
+			line: `{"timestamp":"2025-01-01T00:10:00Z","error":"no delay data"}`,
+			want: "2025-01-01T00:10:00Z\terror\t(no delay data)",
+		},
+	}
+	for _, tc := range cases {
+		got, err := formatHistoryEvent([]byte(tc.line), Config{})
+		if err != nil {
+			t.Fatalf("formatHistoryEvent(%s): %v", tc.line, err)
+		}
+		if got != tc.want {
+			t.Fatalf("formatHistoryEvent(%s)=%q want %q", tc.line, got, tc.want)
+		}
+	}
+	if _, err := formatHistoryEvent([]byte("not json"), Config{}); err == nil {
+		t.Fatalf("expected malformed line to fail")
+	}
+}
+
+func TestHistoryTailerFollowsAppendsTruncationAndRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	writeFile := func(content string, flag int) {
+		t.Helper()
+		f, err := os.OpenFile(path, flag|os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatalf("open history: %v", err)
+		}
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatalf("write history: %v", err)
+		}
+		f.Close()
+	}
+	var got []string
+	poll := func(tailer *historyTailer) []string {
+		t.Helper()
+		got = nil
+		if err := tailer.poll(func(line []byte) { got = append(got, string(line)) }); err != nil {
+			t.Fatalf("poll: %v", err)
+		}
+		return got
+	}
+
+	writeFile("{\"n\":0}\n", os.O_TRUNC)
+	tailer := &historyTailer{path: path}
+	if err := tailer.open(true); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer tailer.close()
+	if lines := poll(tailer); len(lines) != 0 {
+		t.Fatalf("existing lines should be skipped, got %v", lines)
+	}
+
+	writeFile("{\"n\":1}\n{\"n\":", os.O_APPEND)
+	if lines := poll(tailer); !reflect.DeepEqual(lines, []string{`{"n":1}`}) {
+		t.Fatalf("unexpected appended lines %v", lines)
+	}
+	writeFile("2}\n", os.O_APPEND)
+	if lines := poll(tailer); !reflect.DeepEqual(lines, []string{`{"n":2}`}) {
+		t.Fatalf("partial line not completed: %v", lines)
+	}
+
+	writeFile("{\"n\":3}\n", os.O_TRUNC)
+	if lines := poll(tailer); !reflect.DeepEqual(lines, []string{`{"n":3}`}) {
+		t.Fatalf("truncation not handled: %v", lines)
+	}
+
+	writeFile("{\"n\":4}\n", os.O_APPEND)
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	writeFile("{\"n\":5}\n", os.O_TRUNC)
+	if lines := poll(tailer); strings.Join(lines, ",") != `{"n":4},{"n":5}` {
+		t.Fatalf("rotation not handled: %v", lines)
+	}
+}
+
+func TestAutoSelectAppendsHistory(t *testing.T) {
+	fc := &fakeController{
+		now:         "US-01",
+		groupDelays: map[string]any{"US-01": 2500, "JP-01": 150},
+	}
+	server := newFakeController(t, fc)
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	cfg := Config{
+		ControllerURL:        server.URL,
+		ProxyGroup:           "GLOBAL",
+		TestURL:              "https://example.com",
+		DelayTimeoutMS:       100,
+		AutoSelectDiffMS:     300,
+		KeepDelayThresholdMS: 2000,
+		HistoryFile:          path,
+	}
+
+	autoSelectOnce(context.Background(), server.Client(), cfg, true, false)
+	fc.groupDelays["US-01"] = 100
+	autoSelectOnce(context.Background(), server.Client(), cfg, true, false)
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read history: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one line per run, got %q", raw)
+	}
+	var switched, kept map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &switched); err != nil {
+		t.Fatalf("decode %s: %v", lines[0], err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &kept); err != nil {
+		t.Fatalf("decode %s: %v", lines[1], err)
+	}
+	if switched["action"] != "switched" || switched["from"] != "US-01" || switched["to"] != "JP-01" || switched["to_delay_ms"] != float64(150) {
+		t.Fatalf("unexpected switch event %v", switched)
+	}
+	if _, err := time.Parse(time.RFC3339, switched["timestamp"].(string)); err != nil {
+		t.Fatalf("expected RFC3339 timestamp, got %v", switched["timestamp"])
+	}
+	if kept["action"] != "kept" || kept["reason"] == "" {
+		t.Fatalf("unexpected kept event %v", kept)
+	}
+}
+
+func TestHistoryRecordsGroupsAndSkips(t *testing.T) {
+	fc := &fakeController{now: "JP-01", groupDelays: map[string]any{"JP-01": 150}}
+	server := newFakeController(t, fc)
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	cfg := Config{
+		ControllerURL:        server.URL,
+		ProxyGroup:           "GLOBAL",
+		ProxyGroups:          []string{"GLOBAL", "Chat"},
+		TestURL:              "https://example.com",
+		DelayTimeoutMS:       100,
+		AutoSelectDiffMS:     300,
+		KeepDelayThresholdMS: 2000,
+		HistoryFile:          path,
+	}
+
+	captureStdout(t, func() { autoSelectGroups(server.Client(), cfg, true, true) })
+	cfg.ProxyGroups = nil
+	recordHistory(cfg, map[string]any{"action": "skipped", "reason": "gated"})
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read history: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected one line per group and one for the skip, got %q", raw)
+	}
+	for i, want := range []string{"GLOBAL", "Chat", ""} {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(lines[i]), &event); err != nil {
+			t.Fatalf("decode %s: %v", lines[i], err)
+		}
+		group, _ := event["group"].(string)
+		if group != want {
+			t.Fatalf("line %d: expected group %q, got %v", i, want, event)
+		}
+	}
+	if !strings.Contains(lines[2], `"reason":"gated"`) {
+		t.Fatalf("expected the gated skip recorded, got %s", lines[2])
+	}
+}
+
+func TestAppendHistoryConcurrentAndUnwritable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			appendHistory(path, map[string]any{"action": "kept", "reason": strings.Repeat("x", 4096), "n": i}, time.Now())
+		}(i)
+	}
+	wg.Wait()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read history: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if len(lines) != 20 {
+		t.Fatalf("expected 20 lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		if !json.Valid([]byte(line)) {
+			t.Fatalf("interleaved history line %q", line[:64])
+		}
+	}
+
+	// A missing directory only logs a warning.
+	appendHistory(filepath.Join(t.TempDir(), "missing", "history.jsonl"), map[string]any{"action": "kept"}, time.Now())
+}
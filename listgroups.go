@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// listGroups returns the controller's proxy groups, sorted by name, each
+// with its type and current selection.
+func listGroups(client *http.Client, cfg Config) ([]map[string]any, error) {
+	payload, err := controllerRequest(context.Background(), client, cfg, http.MethodGet, cfg.ControllerURL+"/proxies", nil)
+	if err != nil {
+		return nil, err
+	}
+	proxies, _ := payload["proxies"].(map[string]any)
+	groups := make([]map[string]any, 0)
+	for name, raw := range proxies {
+		item, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		groupType, _ := item["type"].(string)
+		if !isGroupType(groupType) {
+			continue
+		}
+		now, _ := item["now"].(string)
+		members, _ := item["all"].([]any)
+		groups = append(groups, map[string]any{
+			"name":         name,
+			"type":         groupType,
+			"now":          now,
+			"members":      len(members),
+			"auto_managed": isAutoManagedGroup(groupType),
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i]["name"].(string) < groups[j]["name"].(string)
+	})
+	return groups, nil
+}
+
+// isGroupType reports whether a /proxies entry type is a proxy group rather
+// than a single proxy.
+func isGroupType(groupType string) bool {
+	switch strings.ToLower(groupType) {
+	case "selector", "urltest", "fallback", "loadbalance", "relay":
+		return true
+	}
+	return false
+}
+
+// listGroupsOnce is --list-groups: it prints every proxy group so the right
+// MIHOMO_PROXY_GROUP can be picked, and returns the exit code.
+func listGroupsOnce(client *http.Client, cfg Config, jsonOutput bool) int {
+	groups, err := listGroups(client, cfg)
+	if err != nil {
+		if jsonOutput {
+			printJSON(map[string]any{"error": err.Error()})
+		} else {
+			fmt.Fprintf(os.Stderr, "Listing groups failed: %v\n", err)
+		}
+		return 1
+	}
+	if jsonOutput {
+		printJSON(map[string]any{"groups": groups})
+		return 0
+	}
+	// Names are printed as-is, emoji included, so they can be copied into
+	// MIHOMO_PROXY_GROUP.
+	for _, group := range groups {
+		now := group["now"].(string)
+		if now == "" {
+			now = "-"
+		}
+		fmt.Printf("%s\t%s\t%s\n", group["name"], group["type"], sanitizeName(now))
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestListGroups(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/proxies" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"proxies":{
+			"GLOBAL":{"type":"Selector","now":"🚀 节点选择","all":["DIRECT","🚀 节点选择","Auto"]},
+			"🚀 节点选择":{"type":"Selector","now":"JP-01","all":["JP-01","SG-01"]},
+			"Auto":{"type":"URLTest","now":"SG-01","all":["JP-01","SG-01"]},
+			"JP-01":{"type":"Shadowsocks"},
+			"DIRECT":{"type":"Direct"}
+		}}`))
+	}))
+	t.Cleanup(server.Close)
+	cfg := Config{ControllerURL: server.URL}
+
+	groups, err := listGroups(server.Client(), cfg)
+	if err != nil {
+		t.Fatalf("listGroups: %v", err)
+	}
+	var names []string
+	for _, group := range groups {
+		names = append(names, group["name"].(string))
+	}
+	if strings.Join(names, ",") != "Auto,GLOBAL,🚀 节点选择" {
+		t.Fatalf("unexpected groups %v", names)
+	}
+	if groups[0]["now"] != "SG-01" || groups[0]["auto_managed"] != true || groups[1]["members"] != 3 {
+		t.Fatalf("unexpected group details %v", groups)
+	}
+
+	var code int
+	out := captureStdout(t, func() { code = listGroupsOnce(server.Client(), cfg, false) })
+	if code != 0 || !strings.Contains(string(out), "🚀 节点选择\tSelector\tJP-01\n") {
+		t.Fatalf("unexpected text output (exit %d) %q", code, out)
+	}
+
+	payload := decodeJSONOutput(t, captureStdout(t, func() { code = listGroupsOnce(server.Client(), cfg, true) }))
+	if list, _ := payload["groups"].([]any); code != 0 || len(list) != 3 {
+		t.Fatalf("unexpected JSON output (exit %d) %v", code, payload)
+	}
+
+	cfg.ControllerURL = "http://127.0.0.1:1"
+	payload = decodeJSONOutput(t, captureStdout(t, func() { code = listGroupsOnce(&http.Client{}, cfg, true) }))
+	if code != 1 || payload["error"] == nil {
+		t.Fatalf("expected an error for an unreachable controller, got exit %d %v", code, payload)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func acquireFileLock(path string) (*os.File, error) {
+	return nil, errors.New("LOCK_FILE is only supported on Unix platforms")
+}
+
+func releaseFileLock(f *os.File) {}
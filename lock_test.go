@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAutoSelectSkipsWhenLockHeld(t *testing.T) {
+	fc := &fakeController{now: "A", groupDelays: map[string]any{"A": 5000, "B": 10}}
+	server := newFakeController(t, fc)
+
+	cfg := Config{
+		ControllerURL:        server.URL,
+		ProxyGroup:           "PROXY",
+		TestURL:              "https://example.com",
+		DelayTimeoutMS:       3000,
+		AutoSelectDiffMS:     100,
+		KeepDelayThresholdMS: 200,
+		LockFile:             filepath.Join(t.TempDir(), "monitor.lock"),
+	}
+
+	lock, err := acquireFileLock(cfg.LockFile)
+	if err != nil {
+		t.Skipf("file locking unavailable: %v", err)
+	}
+	payload := decodeJSONOutput(t, captureStdout(t, func() {
+		autoSelectOnce(context.Background(), server.Client(), cfg, true, false)
+	}))
+	releaseFileLock(lock)
+
+	if payload["action"] != "skipped" || payload["reason"] != "another instance holds the lock" {
+		t.Fatalf("unexpected result while locked: %#v", payload)
+	}
+	if atomic.LoadInt32(&fc.putCalls) != 0 {
+		t.Fatalf("expected no PUT calls while locked, got %d", fc.putCalls)
+	}
+
+	payload = decodeJSONOutput(t, captureStdout(t, func() {
+		autoSelectOnce(context.Background(), server.Client(), cfg, true, false)
+	}))
+	if payload["action"] != "switched" {
+		t.Fatalf("expected switch after lock release, got %#v", payload)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// logLevel is LOG_LEVEL. Messages below the configured level are dropped;
+// the rest go to the standard logger unchanged, so output at the default
+// level looks the same as plain log.Printf. The zero value is info.
+type logLevel int32
+
+const (
+	levelDebug logLevel = iota - 1
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+var minLogLevel atomic.Int32
+
+func (l logLevel) String() string {
+	if l < levelDebug || l > levelError {
+		return fmt.Sprintf("logLevel(%d)", int32(l))
+	}
+	return logLevelNames[l-levelDebug]
+}
+
+func parseLogLevel(raw string) (logLevel, error) {
+	name := strings.ToLower(strings.TrimSpace(raw))
+	switch name {
+	case "":
+		return levelInfo, nil
+	case "warning":
+		return levelWarn, nil
+	}
+	for i, known := range logLevelNames {
+		if name == known {
+			return levelDebug + logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("LOG_LEVEL must be one of debug, info, warn, error; got %q", raw)
+}
+
+func setLogLevel(level logLevel) {
+	minLogLevel.Store(int32(level))
+}
+
+func logEnabled(level logLevel) bool {
+	return int32(level) >= minLogLevel.Load()
+}
+
+func logf(level logLevel, format string, args ...any) {
+	if !logEnabled(level) {
+		return
+	}
+	if level == levelDebug {
+		format = "Debug: " + format
+	}
+	log.Printf(format, args...)
+}
+
+func logDebugf(format string, args ...any) { logf(levelDebug, format, args...) }
+func logInfof(format string, args ...any)  { logf(levelInfo, format, args...) }
+func logWarnf(format string, args ...any)  { logf(levelWarn, format, args...) }
+func logErrorf(format string, args ...any) { logf(levelError, format, args...) }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for raw, want := range map[string]logLevel{"": levelInfo, "DEBUG": levelDebug, " info ": levelInfo, "warn": levelWarn, "warning": levelWarn, "error": levelError} {
+		got, err := parseLogLevel(raw)
+		if err != nil || got != want {
+			t.Fatalf("parseLogLevel(%q) = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil || !strings.Contains(err.Error(), `"verbose"`) {
+		t.Fatalf("expected LOG_LEVEL error, got %v", err)
+	}
+	if levelWarn.String() != "warn" || logLevel(0) != levelInfo {
+		t.Fatalf("unexpected level names or zero value")
+	}
+}
+
+func TestLeveledLogging(t *testing.T) {
+	var buf bytes.Buffer
+	output, flags := log.Writer(), log.Flags()
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(output)
+		log.SetFlags(flags)
+		setLogLevel(levelInfo)
+	})
+	log.SetOutput(&buf)
+
+	logDebugf("hidden %d", 1)
+	logInfof("shown %d", 2)
+	if buf.String() != "shown 2\n" {
+		t.Fatalf("default level should hide debug and keep info unchanged, got %q", buf.String())
+	}
+
+	buf.Reset()
+	setLogLevel(levelDebug)
+	logDebugf("retry %d", 3)
+	if buf.String() != "Debug: retry 3\n" {
+		t.Fatalf("unexpected debug line %q", buf.String())
+	}
+
+	buf.Reset()
+	setLogLevel(levelError)
+	logInfof("info")
+	logWarnf("warn")
+	logErrorf("error")
+	if buf.String() != "error\n" {
+		t.Fatalf("error level should only print errors, got %q", buf.String())
+	}
+}
@@ -3,22 +3,31 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"os/signal"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"text/template"
 	"time"
 	"unicode"
 	"unicode/utf16"
@@ -31,8 +40,11 @@ import (
 type Config struct {
 	ControllerURL        string
 	ControllerSecret     string
+	ControllerFallback   string
+	FallbackSecret       string
 	ProxyGroup           string
 	TestURL              string
+	TestURLs             []string
 	DelayTimeoutMS       int
 	AutoSelectDiffMS     int
 	MonitorIntervalS     int
@@ -40,24 +52,220 @@ type Config struct {
 	KeepDelayThresholdMS int
 	ProxyAddr            string
 	FilterHKNodes        bool
+	HKFilterRegex        *regexp.Regexp
+	HKExceptions         []*regexp.Regexp
+	DelayFieldCandidates []string
+	EndpointRetry        int
+	ExpectBody           string
+	LockFile             string
+	CloseConnsOnSwitch   bool
+	ResultSinkURL        string
+	SelectStrategy       string
+	MaxDelayAgeS         int
+	FilterInfoNodes      bool
+	InfoNodePatterns     []*regexp.Regexp
+	OutputDelayUnit      string
+	GateCommand          string
+	PIDFile              string
+	CheckTLSExpiry       bool
+	NoFollowRedirects    bool
+	StickyProxies        []*regexp.Regexp
+	ProbeOrder           string
+	DelaySamples         int
+	DelayTrim            int
+	EndpointNetwork      string
+	SpeedtestBytes       int64
+	ScoreExpr            *scoreExpr
+	OutputTemplate       *template.Template
+	ExitIPURL            string
+	AvoidSameExitIP      bool
+	AdminAddr            string
+	AlertOnly            bool
+	EndpointHistory      int
+	EndpointHistoryEvery int
+	SpreadHistory        int
+	SwitchStatuses       []int
+	ForceStart           bool
+	GateTimeoutS         int
+	HistoryFile          string
+	Schedule             []scheduleWindow
+	WatchWindow          int
+	PauseFile            string
+	FallbackOrder        []string
+	CheckDirectBaseline  bool
+	HealthPath           string
+	CacheTTLS            int
+	ProbePriority        []*regexp.Regexp
+	ProbeCandidateLimit  int
+	SkipGroupValidation  bool
+	DesktopNotify        bool
+	NotifyWebhookURL     string
+	WebhookBatchS        int
+	ReportControllerLoad bool
+	ReliabilityWeight    float64
+	EventFormat          string
+	ResumeSkipThresholdS int
+	ExcludeRegions       []string
+	IncludeRegions       []string
+	FilterNameRegex      []*regexp.Regexp
+	DelayAggregate       string
+	SwitchCooldownS      int
+	MetricsAddr          string
+	ProxyGroups          []string
+	ControllerRetries    int
+	ControllerBackoffMS  int
+	EndpointMethod       string
+	EndpointOKStatus     []statusRange
+	DelayEWMAAlpha       float64
+	EndpointQuorum       endpointQuorum
+	ProbeConcurrency     int
+	LogLevel             logLevel
+	HealthAddr           string
+	ControllerCAFile     string
+	ControllerInsecure   bool
+	EndpointWeight       float64
+	RunTimeoutMS         int
+	RefreshBeforeRead    bool
+	ForceOffDirect       bool
+	ControllerBasicUser  string
+	ControllerBasicPass  string
+	KeepIfRankWithin     int
+
+	controller    *controllerState
+	exitIPs       *exitIPCache
+	probeOffset   int
+	recentRegions []string
+	delays        *delayCache
+	lastSwitch    *switchContext
+	lastSwitchAt  time.Time
+	reliability   *delayWindow
+	collectOnly   bool // emitResult returns results without printing them
+	ewma          *delayEWMA
+	configFile    string
 }
 
 type ProxyDelay struct {
 	Name    string
 	DelayMS int
+	// Loss is the fraction of DELAY_SAMPLES in which the node timed out.
+	Loss float64
+}
+
+type GroupInfo struct {
+	Now  string
+	Type string
+	All  []string
+}
+
+type DelayBucket struct {
+	Label string
+	MinMS int
+	MaxMS int
+	Count int
+}
+
+type PruneEntry struct {
+	Name     string `json:"name"`
+	Failures int    `json:"failures"`
 }
 
 type EndpointResult struct {
-	URL       string `json:"url"`
-	Reachable bool   `json:"reachable"`
-	LatencyMS int    `json:"latency_ms"`
+	URL        string `json:"url"`
+	Reachable  bool   `json:"reachable"`
+	LatencyMS  int    `json:"latency_ms"`
+	StatusCode int    `json:"status_code,omitempty"`
+	// FinalURL is where the request ended up after redirects; Redirected is
+	// set when it differs from the requested URL (e.g. a captive portal).
+	FinalURL      string `json:"final_url,omitempty"`
+	Redirected    bool   `json:"redirected,omitempty"`
+	BodyBytes     *int64 `json:"body_bytes,omitempty"`
+	CertExpires   string `json:"cert_expires,omitempty"`
+	DaysRemaining *int   `json:"days_remaining,omitempty"`
+
+	ThroughputMbps *float64 `json:"throughput_mbps,omitempty"`
+
+	IPv4 *EndpointResult `json:"ipv4,omitempty"`
+	IPv6 *EndpointResult `json:"ipv6,omitempty"`
+	// Unsupported marks an IPv4/IPv6-only probe that could not be made
+	// because it would go through MIHOMO_PROXY_ADDR.
+	Unsupported bool `json:"unsupported,omitempty"`
+
+	// DirectLatencyMS is the CHECK_DIRECT_BASELINE latency without the proxy,
+	// -1 when the endpoint is unreachable directly.
+	DirectLatencyMS *int `json:"direct_latency_ms,omitempty"`
+	OverheadMS      *int `json:"proxy_overhead_ms,omitempty"`
 }
 
 var hkTokenRE = regexp.MustCompile(`(?i)(^|[^a-z0-9])hk([^a-z0-9]|$)`)
 
-const endpointProbeCandidateLimit = 10
+// defaultInfoNodePatterns match the fake "nodes" subscription providers use
+// to advertise remaining traffic, expiry dates, and websites.
+var defaultInfoNodePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`剩余|到期|过期|套餐|重置|官网|网址|公告`),
+	regexp.MustCompile(`(?i)\b(expire[ds]?|expiry|expiration|traffic|remaining|reset|website)\b`),
+	regexp.MustCompile(`(?i)\d+(\.\d+)?\s*(GB|TB|MB)\b`),
+	regexp.MustCompile(`\d{4}[-/.]\d{1,2}[-/.]\d{1,2}`),
+}
+
+const defaultProbeCandidateLimit = 10
+
+const defaultProbeConcurrency = 4
+
+const dryRunAlternativeLimit = 3
+
+const endpointRetryDelay = 500 * time.Millisecond
+
+const expectBodyReadLimit = 1 << 20
+
+const speedtestTag = "speedtest+"
+
+const testURLConcurrency = 4
+
+const delayRequestSlack = time.Second
+
+const refreshGrace = 500 * time.Millisecond
+
+var errLockHeld = errors.New("another instance holds the lock")
+
+var defaultHistogramBounds = []int{100, 300, 1000}
+
+var defaultDelayFieldCandidates = []string{"delay", "lastDelay", "delay.value"}
+
+// isExcludedProxy reports whether the region filter drops name. Without
+// EXCLUDE_REGIONS or INCLUDE_REGIONS that means looking like a Hong Kong node;
+// with them, matching an exclude token or, when INCLUDE_REGIONS is set,
+// matching none of its tokens. Names matching HK_FILTER_EXCEPTIONS are never
+// excluded.
+func isExcludedProxy(name string, cfg Config) bool {
+	if matchesAny(name, cfg.HKExceptions) {
+		return false
+	}
+	if len(cfg.ExcludeRegions) == 0 && len(cfg.IncludeRegions) == 0 {
+		return isHKProxy(name, cfg)
+	}
+	for _, token := range cfg.ExcludeRegions {
+		if matchesRegion(name, token, cfg) {
+			return true
+		}
+	}
+	if len(cfg.IncludeRegions) == 0 {
+		return false
+	}
+	for _, token := range cfg.IncludeRegions {
+		if matchesRegion(name, token, cfg) {
+			return false
+		}
+	}
+	return true
+}
 
-func isExcludedProxy(name string) bool {
+// isHKProxy reports whether name looks like a Hong Kong node. HK_FILTER_REGEX
+// replaces the built-in hkTokenRE when set.
+func isHKProxy(name string, cfg Config) bool {
+	tokenRE := cfg.HKFilterRegex
+	if tokenRE == nil {
+		tokenRE = hkTokenRE
+	}
 	lowered := strings.ToLower(name)
 	if strings.Contains(name, "香港") {
 		return true
@@ -65,7 +273,54 @@ func isExcludedProxy(name string) bool {
 	if strings.Contains(lowered, "hong kong") {
 		return true
 	}
-	return hkTokenRE.MatchString(lowered)
+	return tokenRE.MatchString(lowered)
+}
+
+// matchesRegion reports whether name belongs to the region named by token. A
+// known country code (see nameRegions) matches that region's keywords and
+// standalone tokens; anything else is a case-insensitive substring.
+func matchesRegion(name, token string, cfg Config) bool {
+	code := strings.ToUpper(token)
+	if code == "HK" {
+		return isHKProxy(name, cfg)
+	}
+	lowered := strings.ToLower(name)
+	for _, region := range nameRegions {
+		if region.code != code {
+			continue
+		}
+		for _, keyword := range region.keywords {
+			if strings.Contains(lowered, keyword) {
+				return true
+			}
+		}
+		return region.tokenRE.MatchString(lowered)
+	}
+	return strings.Contains(lowered, strings.ToLower(token))
+}
+
+func isInfoNode(name string, extra []*regexp.Regexp) bool {
+	for _, re := range defaultInfoNodePatterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	for _, re := range extra {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isFilteredProxy(name string, cfg Config) bool {
+	if cfg.FilterHKNodes && isExcludedProxy(name, cfg) {
+		return true
+	}
+	if matchesAny(name, cfg.FilterNameRegex) {
+		return true
+	}
+	return cfg.FilterInfoNodes && isInfoNode(name, cfg.InfoNodePatterns)
 }
 
 func parseBoolEnv(name string, defaultVal bool) bool {
@@ -80,7 +335,7 @@ func parseBoolEnv(name string, defaultVal bool) bool {
 	case "0", "false", "no", "off":
 		return false
 	default:
-		log.Printf("Invalid %s=%q, fallback to %v", name, raw, defaultVal)
+		logWarnf("Invalid %s=%q, fallback to %v", name, raw, defaultVal)
 		return defaultVal
 	}
 }
@@ -93,6 +348,55 @@ func envOrDefault(name, defaultVal string) string {
 	return v
 }
 
+func parseListEnv(name string) []string {
+	items := make([]string, 0)
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return items
+	}
+	for _, item := range strings.Split(raw, ",") {
+		trimmed := strings.TrimSpace(item)
+		if trimmed != "" {
+			items = append(items, trimmed)
+		}
+	}
+	return items
+}
+
+func parseRegexListEnv(name string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0)
+	for _, item := range parseListEnv(name) {
+		re, err := regexp.Compile(item)
+		if err != nil {
+			return nil, fmt.Errorf("%s contains invalid regex %q: %v", name, item, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// parseGlobListEnv compiles comma-separated shell-style patterns ("*" and "?")
+// into anchored regexps.
+func parseGlobListEnv(name string) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, 0)
+	for _, item := range parseListEnv(name) {
+		expr := regexp.QuoteMeta(item)
+		expr = strings.ReplaceAll(expr, `\*`, ".*")
+		expr = strings.ReplaceAll(expr, `\?`, ".")
+		patterns = append(patterns, regexp.MustCompile("^"+expr+"$"))
+	}
+	return patterns
+}
+
+func matchesAny(name string, patterns []*regexp.Regexp) bool {
+	for _, re := range patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
 func parseIntEnv(name string, defaultVal int) (int, error) {
 	v := strings.TrimSpace(os.Getenv(name))
 	if v == "" {
@@ -105,6 +409,32 @@ func parseIntEnv(name string, defaultVal int) (int, error) {
 	return parsed, nil
 }
 
+// parseDurationEnv reads a duration string such as "3s" from durName, falling
+// back to the integer intName expressed in unit. It returns the value in unit
+// and the name of the variable it came from, for validation messages.
+func parseDurationEnv(durName, intName string, unit time.Duration, defaultVal int) (int, string, error) {
+	v := strings.TrimSpace(os.Getenv(durName))
+	if v == "" {
+		parsed, err := parseIntEnv(intName, defaultVal)
+		return parsed, intName, err
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, durName, fmt.Errorf("%s must be a duration like 500ms or 3s", durName)
+	}
+	if d%unit != 0 {
+		return 0, durName, fmt.Errorf("%s must be a whole number of %s", durName, durationUnitName(unit))
+	}
+	return int(d / unit), durName, nil
+}
+
+func durationUnitName(unit time.Duration) string {
+	if unit == time.Second {
+		return "seconds"
+	}
+	return "milliseconds"
+}
+
 func loadConfig() (Config, error) {
 	_ = godotenv.Overload()
 
@@ -113,23 +443,14 @@ func loadConfig() (Config, error) {
 		return Config{}, errors.New("MIHOMO_CONTROLLER_URL is required")
 	}
 
-	rawEndpoints := strings.TrimSpace(os.Getenv("ENDPOINT_URLS"))
-	endpointURLs := make([]string, 0)
-	if rawEndpoints != "" {
-		for _, item := range strings.Split(rawEndpoints, ",") {
-			trimmed := strings.TrimSpace(item)
-			if trimmed != "" {
-				endpointURLs = append(endpointURLs, trimmed)
-			}
-		}
-	}
+	endpointURLs := parseListEnv("ENDPOINT_URLS")
 
-	delayTimeoutMS, err := parseIntEnv("DELAY_TIMEOUT_MS", 3000)
+	delayTimeoutMS, delayTimeoutVar, err := parseDurationEnv("DELAY_TIMEOUT", "DELAY_TIMEOUT_MS", time.Millisecond, 3000)
 	if err != nil {
 		return Config{}, err
 	}
 	if delayTimeoutMS <= 0 {
-		return Config{}, errors.New("DELAY_TIMEOUT_MS must be > 0")
+		return Config{}, fmt.Errorf("%s must be > 0", delayTimeoutVar)
 	}
 	autoSelectDiffMS, err := parseIntEnv("AUTO_SELECT_DIFF_MS", 300)
 	if err != nil {
@@ -138,206 +459,1391 @@ func loadConfig() (Config, error) {
 	if autoSelectDiffMS < 0 {
 		return Config{}, errors.New("AUTO_SELECT_DIFF_MS must be >= 0")
 	}
-	monitorIntervalS, err := parseIntEnv("MONITOR_INTERVAL_S", 300)
+	monitorIntervalS, monitorIntervalVar, err := parseDurationEnv("MONITOR_INTERVAL", "MONITOR_INTERVAL_S", time.Second, 300)
 	if err != nil {
 		return Config{}, err
 	}
 	if monitorIntervalS <= 0 {
-		return Config{}, errors.New("MONITOR_INTERVAL_S must be > 0")
+		return Config{}, fmt.Errorf("%s must be > 0", monitorIntervalVar)
 	}
-	keepDelayThresholdMS, err := parseIntEnv("KEEP_DELAY_THRESHOLD_MS", 2000)
+	runTimeoutMS, err := parseIntEnv("RUN_TIMEOUT_MS", monitorIntervalS*1000)
+	if err != nil {
+		return Config{}, err
+	}
+	if runTimeoutMS < 0 {
+		return Config{}, errors.New("RUN_TIMEOUT_MS must be >= 0")
+	}
+	basicUser, basicPass := os.Getenv("CONTROLLER_BASIC_USER"), os.Getenv("CONTROLLER_BASIC_PASS")
+	if basicUser == "" && basicPass != "" {
+		return Config{}, errors.New("CONTROLLER_BASIC_PASS requires CONTROLLER_BASIC_USER")
+	}
+	keepDelayThresholdMS, keepDelayThresholdVar, err := parseDurationEnv("KEEP_DELAY_THRESHOLD", "KEEP_DELAY_THRESHOLD_MS", time.Millisecond, 2000)
 	if err != nil {
 		return Config{}, err
 	}
 	if keepDelayThresholdMS < 0 {
-		return Config{}, errors.New("KEEP_DELAY_THRESHOLD_MS must be >= 0")
+		return Config{}, fmt.Errorf("%s must be >= 0", keepDelayThresholdVar)
 	}
 
-	proxyAddr := strings.TrimSpace(os.Getenv("MIHOMO_PROXY_ADDR"))
-	if len(endpointURLs) > 0 && proxyAddr == "" {
-		log.Printf("Warning: ENDPOINT_URLS is set but MIHOMO_PROXY_ADDR is empty; endpoint checks are disabled")
+	endpointRetry, err := parseIntEnv("ENDPOINT_RETRY", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if endpointRetry < 0 {
+		return Config{}, errors.New("ENDPOINT_RETRY must be >= 0")
 	}
 
-	return Config{
-		ControllerURL:        strings.TrimRight(controllerURL, "/"),
-		ControllerSecret:     strings.TrimSpace(os.Getenv("MIHOMO_CONTROLLER_SECRET")),
-		ProxyGroup:           envOrDefault("MIHOMO_PROXY_GROUP", "GLOBAL"),
-		TestURL:              envOrDefault("TEST_URL", "https://google.com"),
-		DelayTimeoutMS:       delayTimeoutMS,
-		AutoSelectDiffMS:     autoSelectDiffMS,
-		MonitorIntervalS:     monitorIntervalS,
-		EndpointURLs:         endpointURLs,
-		KeepDelayThresholdMS: keepDelayThresholdMS,
-		ProxyAddr:            proxyAddr,
-		FilterHKNodes:        parseBoolEnv("FILTER_HK_NODES", true),
-	}, nil
-}
-
-func setAuthHeader(req *http.Request, secret string) {
-	if secret != "" {
-		req.Header.Set("Authorization", "Bearer "+secret)
+	maxDelayAgeS, err := parseIntEnv("MAX_DELAY_AGE_S", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if maxDelayAgeS < 0 {
+		return Config{}, errors.New("MAX_DELAY_AGE_S must be >= 0")
 	}
-}
 
-func toInt(value any) (int, bool) {
-	switch v := value.(type) {
-	case int:
-		return v, true
-	case int32:
-		return int(v), true
-	case int64:
-		return int(v), true
-	case float64:
-		return int(v), true
-	case json.Number:
-		i, err := v.Int64()
-		if err != nil {
-			return 0, false
-		}
-		return int(i), true
-	case string:
-		i, err := strconv.Atoi(v)
-		if err != nil {
-			return 0, false
-		}
-		return i, true
-	default:
-		return 0, false
+	infoNodePatterns, err := parseRegexListEnv("INFO_NODE_PATTERNS")
+	if err != nil {
+		return Config{}, err
 	}
-}
 
-func parseGroupDelays(payload map[string]any, filterHKNodes bool) []ProxyDelay {
-	delays := make([]ProxyDelay, 0)
+	filterNameRegex, err := parseRegexListEnv("FILTER_NAME_REGEX")
+	if err != nil {
+		return Config{}, err
+	}
 
-	if delaysRaw, ok := payload["delays"].(map[string]any); ok {
-		for name, delay := range delaysRaw {
-			if filterHKNodes && isExcludedProxy(name) {
-				continue
-			}
-			delayMS, ok := toInt(delay)
-			if !ok {
-				continue
-			}
-			if delayMS >= 0 {
-				delays = append(delays, ProxyDelay{Name: name, DelayMS: delayMS})
-			}
-		}
-		return delays
+	fallbackOrder, err := parseFallbackOrder(parseListEnv("UNREACHABLE_FALLBACK_ORDER"))
+	if err != nil {
+		return Config{}, err
 	}
 
-	for name, delay := range payload {
-		if filterHKNodes && isExcludedProxy(name) {
-			continue
-		}
-		delayMS, ok := toInt(delay)
-		if !ok {
-			continue
-		}
-		if delayMS >= 0 {
-			delays = append(delays, ProxyDelay{Name: name, DelayMS: delayMS})
-		}
+	controllerRetries, err := parseIntEnv("CONTROLLER_RETRIES", 0)
+	if err != nil {
+		return Config{}, err
 	}
-	if len(delays) > 0 {
-		return delays
+	if controllerRetries < 0 {
+		return Config{}, errors.New("CONTROLLER_RETRIES must be >= 0")
+	}
+	controllerBackoffMS, err := parseIntEnv("CONTROLLER_RETRY_BACKOFF_MS", 500)
+	if err != nil {
+		return Config{}, err
+	}
+	if controllerBackoffMS < 0 {
+		return Config{}, errors.New("CONTROLLER_RETRY_BACKOFF_MS must be >= 0")
 	}
 
-	if proxiesRaw, ok := payload["proxies"].([]any); ok {
-		for _, item := range proxiesRaw {
-			proxyItem, ok := item.(map[string]any)
-			if !ok {
-				continue
-			}
-			name, ok := proxyItem["name"].(string)
-			if !ok {
-				continue
-			}
-			if filterHKNodes && isExcludedProxy(name) {
-				continue
-			}
-			delayMS, ok := toInt(proxyItem["delay"])
-			if !ok {
-				continue
-			}
-			if delayMS >= 0 {
-				delays = append(delays, ProxyDelay{Name: name, DelayMS: delayMS})
-			}
-		}
-		return delays
+	proxyGroupList := parseListEnv("MIHOMO_PROXY_GROUP")
+	if len(proxyGroupList) == 0 {
+		proxyGroupList = []string{"GLOBAL"}
 	}
 
-	name, hasName := payload["name"].(string)
-	delay, hasDelay := payload["delay"]
-	if hasName && hasDelay {
-		if filterHKNodes && isExcludedProxy(name) {
-			return []ProxyDelay{}
-		}
-		delayMS, ok := toInt(delay)
-		if ok && delayMS >= 0 {
-			return []ProxyDelay{{Name: name, DelayMS: delayMS}}
-		}
+	switchCooldownS, err := parseIntEnv("SWITCH_COOLDOWN_S", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if switchCooldownS < 0 {
+		return Config{}, errors.New("SWITCH_COOLDOWN_S must be >= 0")
 	}
 
-	log.Printf("Unexpected delay payload shape: %v", payload)
-	return []ProxyDelay{}
-}
+	resumeSkipThresholdS, err := parseIntEnv("RESUME_SKIP_THRESHOLD_S", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if resumeSkipThresholdS < 0 {
+		return Config{}, errors.New("RESUME_SKIP_THRESHOLD_S must be >= 0")
+	}
 
-func controllerRequest(client *http.Client, cfg Config, method, endpoint string, body []byte) (map[string]any, error) {
-	var reader *bytes.Reader
-	if body == nil {
-		reader = bytes.NewReader([]byte{})
-	} else {
-		reader = bytes.NewReader(body)
+	webhookBatchS, err := parseIntEnv("WEBHOOK_BATCH_INTERVAL_S", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if webhookBatchS < 0 {
+		return Config{}, errors.New("WEBHOOK_BATCH_INTERVAL_S must be >= 0")
 	}
-	req, err := http.NewRequest(method, endpoint, reader)
+
+	cacheTTLS, err := parseIntEnv("CACHE_TTL_S", 0)
 	if err != nil {
-		return nil, err
+		return Config{}, err
 	}
-	setAuthHeader(req, cfg.ControllerSecret)
-	if body != nil {
-		req.Header.Set("Content-Type", "application/json")
+	if cacheTTLS < 0 {
+		return Config{}, errors.New("CACHE_TTL_S must be >= 0")
 	}
-	resp, err := client.Do(req)
+
+	watchWindow, err := parseIntEnv("WATCH_WINDOW", 20)
 	if err != nil {
-		return nil, err
+		return Config{}, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("request failed: %s", resp.Status)
+	if watchWindow <= 0 {
+		return Config{}, errors.New("WATCH_WINDOW must be > 0")
 	}
-	if resp.StatusCode == http.StatusNoContent || resp.ContentLength == 0 {
-		return map[string]any{}, nil
+
+	schedule, err := parseSchedule(os.Getenv("SCHEDULE"))
+	if err != nil {
+		return Config{}, err
 	}
-	var payload map[string]any
-	decoder := json.NewDecoder(resp.Body)
-	decoder.UseNumber()
-	if err := decoder.Decode(&payload); err != nil {
-		if errors.Is(err, io.EOF) {
-			return map[string]any{}, nil
+
+	var hkFilterRegex *regexp.Regexp
+	if raw := strings.TrimSpace(os.Getenv("HK_FILTER_REGEX")); raw != "" {
+		hkFilterRegex, err = regexp.Compile(raw)
+		if err != nil {
+			return Config{}, fmt.Errorf("HK_FILTER_REGEX is invalid: %v", err)
 		}
-		return nil, err
 	}
-	return payload, nil
-}
-
-func getGroupDelaysWithFilter(client *http.Client, cfg Config, filterHKNodes bool) []ProxyDelay {
-	endpoint := fmt.Sprintf("%s/group/%s/delay", cfg.ControllerURL, url.PathEscape(cfg.ProxyGroup))
-	params := url.Values{}
-	params.Set("url", cfg.TestURL)
-	params.Set("timeout", strconv.Itoa(cfg.DelayTimeoutMS))
-	endpoint = endpoint + "?" + params.Encode()
 
-	payload, err := controllerRequest(client, cfg, http.MethodGet, endpoint, nil)
+	spreadHistory, err := parseIntEnv("SPREAD_HISTORY", 2)
 	if err != nil {
-		log.Printf("Group delay check failed: %v", err)
-		return []ProxyDelay{}
+		return Config{}, err
 	}
-	return parseGroupDelays(payload, filterHKNodes)
+	if spreadHistory < 0 {
+		return Config{}, errors.New("SPREAD_HISTORY must be >= 0")
+	}
+
+	endpointHistory, err := parseIntEnv("ENDPOINT_HISTORY", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if endpointHistory < 0 {
+		return Config{}, errors.New("ENDPOINT_HISTORY must be >= 0")
+	}
+	endpointHistoryEvery, err := parseIntEnv("ENDPOINT_HISTORY_LOG_EVERY", endpointHistory)
+	if err != nil {
+		return Config{}, err
+	}
+	if endpointHistory > 0 && endpointHistoryEvery <= 0 {
+		return Config{}, errors.New("ENDPOINT_HISTORY_LOG_EVERY must be > 0")
+	}
+
+	testURLList := parseListEnv("TEST_URL")
+	if len(testURLList) == 0 {
+		testURLList = []string{"https://google.com"}
+	}
+
+	delaySamples, err := parseIntEnv("DELAY_SAMPLES", 1)
+	if err != nil {
+		return Config{}, err
+	}
+	if delaySamples < 1 {
+		return Config{}, errors.New("DELAY_SAMPLES must be >= 1")
+	}
+	delayTrim, err := parseIntEnv("DELAY_TRIM", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if delayTrim < 0 {
+		return Config{}, errors.New("DELAY_TRIM must be >= 0")
+	}
+	if delayTrim > 0 && 2*delayTrim >= delaySamples {
+		return Config{}, errors.New("DELAY_TRIM must leave at least one sample (2*DELAY_TRIM < DELAY_SAMPLES)")
+	}
+	delayAggregate := strings.ToLower(envOrDefault("DELAY_AGGREGATE", "mean"))
+	if delayAggregate != "mean" && delayAggregate != "median" {
+		return Config{}, fmt.Errorf("DELAY_AGGREGATE must be mean or median; got %q", delayAggregate)
+	}
+
+	quorum, err := parseEndpointQuorum(os.Getenv("ENDPOINT_QUORUM"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	endpointMethod := strings.ToUpper(envOrDefault("ENDPOINT_METHOD", http.MethodHead))
+	if endpointMethod != http.MethodHead && endpointMethod != http.MethodGet {
+		return Config{}, fmt.Errorf("ENDPOINT_METHOD must be HEAD or GET; got %q", endpointMethod)
+	}
+	endpointOKStatus, err := parseStatusSpec(os.Getenv("ENDPOINT_OK_STATUS"))
+	if err != nil {
+		return Config{}, fmt.Errorf("ENDPOINT_OK_STATUS: %v", err)
+	}
+
+	endpointSwitchStatuses := make([]int, 0)
+	for _, item := range parseListEnv("ENDPOINT_SWITCH_STATUSES") {
+		code, err := strconv.Atoi(item)
+		if err != nil || code < 100 || code > 599 {
+			return Config{}, fmt.Errorf("ENDPOINT_SWITCH_STATUSES must contain HTTP status codes; got %q", item)
+		}
+		endpointSwitchStatuses = append(endpointSwitchStatuses, code)
+	}
+
+	speedtestBytes, err := parseIntEnv("SPEEDTEST_BYTES", 10<<20)
+	if err != nil {
+		return Config{}, err
+	}
+	if speedtestBytes <= 0 {
+		return Config{}, errors.New("SPEEDTEST_BYTES must be > 0")
+	}
+
+	endpointNetwork := strings.ToLower(envOrDefault("ENDPOINT_NETWORK", "tcp"))
+	if endpointNetwork != "tcp" && endpointNetwork != "tcp4" && endpointNetwork != "tcp6" {
+		return Config{}, fmt.Errorf("ENDPOINT_NETWORK must be one of tcp, tcp4, tcp6; got %q", endpointNetwork)
+	}
+
+	var scoreExpression *scoreExpr
+	if raw := strings.TrimSpace(os.Getenv("SCORE_EXPR")); raw != "" {
+		scoreExpression, err = compileScoreExpr(raw)
+		if err != nil {
+			return Config{}, fmt.Errorf("SCORE_EXPR is invalid: %v", err)
+		}
+	}
+
+	// ENDPOINT_WEIGHT is shorthand for SCORE_EXPR=delay + W * endpoint_latency.
+	endpointWeight := 0.0
+	if raw := strings.TrimSpace(os.Getenv("ENDPOINT_WEIGHT")); raw != "" {
+		endpointWeight, err = strconv.ParseFloat(raw, 64)
+		if err != nil || endpointWeight < 0 || math.IsInf(endpointWeight, 0) || math.IsNaN(endpointWeight) {
+			return Config{}, errors.New("ENDPOINT_WEIGHT must be a number >= 0")
+		}
+	}
+	if endpointWeight > 0 && scoreExpression != nil {
+		return Config{}, errors.New("ENDPOINT_WEIGHT cannot be combined with SCORE_EXPR; use endpoint_latency in SCORE_EXPR instead")
+	}
+	if endpointWeight > 0 && len(endpointURLs) > 0 {
+		scoreExpression, err = compileScoreExpr("delay + " + strconv.FormatFloat(endpointWeight, 'f', -1, 64) + " * endpoint_latency")
+		if err != nil {
+			return Config{}, fmt.Errorf("ENDPOINT_WEIGHT: %v", err)
+		}
+	}
+
+	probeOrder := strings.ToLower(envOrDefault("PROBE_ORDER", "fastest"))
+	if probeOrder != "fastest" && probeOrder != "round-robin" {
+		return Config{}, fmt.Errorf("PROBE_ORDER must be one of fastest, round-robin; got %q", probeOrder)
+	}
+
+	probeCandidateLimit, err := parseIntEnv("ENDPOINT_PROBE_CANDIDATE_LIMIT", defaultProbeCandidateLimit)
+	if err != nil {
+		return Config{}, err
+	}
+	if probeCandidateLimit <= 0 {
+		return Config{}, errors.New("ENDPOINT_PROBE_CANDIDATE_LIMIT must be > 0")
+	}
+
+	level, err := parseLogLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	probeConcurrency, err := parseIntEnv("PROBE_CONCURRENCY", defaultProbeConcurrency)
+	if err != nil {
+		return Config{}, err
+	}
+	if probeConcurrency <= 0 {
+		return Config{}, errors.New("PROBE_CONCURRENCY must be > 0")
+	}
+	keepIfRankWithin, err := parseIntEnv("KEEP_IF_RANK_WITHIN", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if keepIfRankWithin < 0 {
+		return Config{}, errors.New("KEEP_IF_RANK_WITHIN must be >= 0")
+	}
+
+	gateTimeoutS, err := parseIntEnv("GATE_TIMEOUT_S", 10)
+	if err != nil {
+		return Config{}, err
+	}
+	if gateTimeoutS <= 0 {
+		return Config{}, errors.New("GATE_TIMEOUT_S must be > 0")
+	}
+
+	outputDelayUnit := strings.ToLower(envOrDefault("OUTPUT_DELAY_UNIT", "ms"))
+	if outputDelayUnit != "ms" && outputDelayUnit != "s" {
+		return Config{}, fmt.Errorf("OUTPUT_DELAY_UNIT must be ms or s; got %q", outputDelayUnit)
+	}
+
+	var outputTemplate *template.Template
+	if raw := os.Getenv("OUTPUT_TEMPLATE"); strings.TrimSpace(raw) != "" {
+		outputTemplate, err = compileOutputTemplate(raw, outputDelayUnit)
+		if err != nil {
+			return Config{}, fmt.Errorf("OUTPUT_TEMPLATE is invalid: %v", err)
+		}
+	}
+
+	selectStrategy := strings.ToLower(envOrDefault("SELECT_STRATEGY", "fastest"))
+	switch selectStrategy {
+	case "fastest", "median", "spread", "reliable":
+	default:
+		return Config{}, fmt.Errorf("SELECT_STRATEGY must be one of fastest, median, spread, reliable; got %q", selectStrategy)
+	}
+
+	eventFormat := strings.ToLower(envOrDefault("EVENT_FORMAT", eventFormatJSON))
+	if eventFormat != eventFormatJSON && eventFormat != eventFormatCloudEvents {
+		return Config{}, fmt.Errorf("EVENT_FORMAT must be json or cloudevents; got %q", eventFormat)
+	}
+
+	delayEWMAAlpha := 0.0
+	if raw := strings.TrimSpace(os.Getenv("DELAY_EWMA_ALPHA")); raw != "" {
+		delayEWMAAlpha, err = strconv.ParseFloat(raw, 64)
+		if err != nil || delayEWMAAlpha < 0 || delayEWMAAlpha > 1 {
+			return Config{}, errors.New("DELAY_EWMA_ALPHA must be a number between 0 and 1")
+		}
+	}
+
+	reliabilityWeight := 0.5
+	if raw := strings.TrimSpace(os.Getenv("RELIABILITY_WEIGHT")); raw != "" {
+		reliabilityWeight, err = strconv.ParseFloat(raw, 64)
+		if err != nil || reliabilityWeight < 0 || reliabilityWeight > 1 {
+			return Config{}, errors.New("RELIABILITY_WEIGHT must be a number between 0 and 1")
+		}
+	}
+
+	proxyAddr := strings.TrimSpace(os.Getenv("MIHOMO_PROXY_ADDR"))
+	if len(endpointURLs) > 0 && proxyAddr == "" {
+		logWarnf("Warning: ENDPOINT_URLS is set but MIHOMO_PROXY_ADDR is empty; endpoint checks are disabled")
+	}
+
+	delayFieldCandidates := []string{"delay"}
+	fallbackFields := parseListEnv("DELAY_FIELD_CANDIDATES")
+	if len(fallbackFields) == 0 {
+		fallbackFields = defaultDelayFieldCandidates
+	}
+	for _, field := range fallbackFields {
+		if field != "delay" {
+			delayFieldCandidates = append(delayFieldCandidates, field)
+		}
+	}
+
+	return Config{
+		ControllerURL:        strings.TrimRight(controllerURL, "/"),
+		ControllerSecret:     strings.TrimSpace(os.Getenv("MIHOMO_CONTROLLER_SECRET")),
+		ControllerFallback:   strings.TrimRight(strings.TrimSpace(os.Getenv("MIHOMO_CONTROLLER_URL_FALLBACK")), "/"),
+		FallbackSecret:       strings.TrimSpace(os.Getenv("MIHOMO_CONTROLLER_SECRET_FALLBACK")),
+		ProxyGroup:           proxyGroupList[0],
+		ProxyGroups:          proxyGroupList,
+		ControllerRetries:    controllerRetries,
+		ControllerBackoffMS:  controllerBackoffMS,
+		EndpointMethod:       endpointMethod,
+		EndpointOKStatus:     endpointOKStatus,
+		DelayEWMAAlpha:       delayEWMAAlpha,
+		EndpointQuorum:       quorum,
+		ProbeConcurrency:     probeConcurrency,
+		LogLevel:             level,
+		HealthAddr:           strings.TrimSpace(os.Getenv("HEALTH_ADDR")),
+		ControllerCAFile:     strings.TrimSpace(os.Getenv("CONTROLLER_CA_FILE")),
+		ControllerInsecure:   parseBoolEnv("CONTROLLER_INSECURE_SKIP_VERIFY", false),
+		EndpointWeight:       endpointWeight,
+		RunTimeoutMS:         runTimeoutMS,
+		RefreshBeforeRead:    parseBoolEnv("REFRESH_BEFORE_READ", false),
+		ForceOffDirect:       parseBoolEnv("FORCE_OFF_DIRECT", false),
+		ControllerBasicUser:  basicUser,
+		ControllerBasicPass:  basicPass,
+		KeepIfRankWithin:     keepIfRankWithin,
+		TestURL:              testURLList[0],
+		TestURLs:             testURLList,
+		DelayTimeoutMS:       delayTimeoutMS,
+		AutoSelectDiffMS:     autoSelectDiffMS,
+		MonitorIntervalS:     monitorIntervalS,
+		EndpointURLs:         endpointURLs,
+		KeepDelayThresholdMS: keepDelayThresholdMS,
+		ProxyAddr:            proxyAddr,
+		FilterHKNodes:        parseBoolEnv("FILTER_HK_NODES", true),
+		HKFilterRegex:        hkFilterRegex,
+		HKExceptions:         parseGlobListEnv("HK_FILTER_EXCEPTIONS"),
+		DelayFieldCandidates: delayFieldCandidates,
+		EndpointRetry:        endpointRetry,
+		ExpectBody:           os.Getenv("TEST_URL_EXPECT_BODY"),
+		LockFile:             strings.TrimSpace(os.Getenv("LOCK_FILE")),
+		CloseConnsOnSwitch:   parseBoolEnv("CLOSE_CONNECTIONS_ON_SWITCH", false),
+		ResultSinkURL:        strings.TrimSpace(os.Getenv("RESULT_SINK_URL")),
+		SelectStrategy:       selectStrategy,
+		MaxDelayAgeS:         maxDelayAgeS,
+		FilterInfoNodes:      parseBoolEnv("FILTER_INFO_NODES", true),
+		InfoNodePatterns:     infoNodePatterns,
+		OutputDelayUnit:      outputDelayUnit,
+		GateCommand:          strings.TrimSpace(os.Getenv("GATE_COMMAND")),
+		PIDFile:              strings.TrimSpace(os.Getenv("PID_FILE")),
+		CheckTLSExpiry:       parseBoolEnv("CHECK_TLS_EXPIRY", false),
+		NoFollowRedirects:    !parseBoolEnv("ENDPOINT_FOLLOW_REDIRECTS", true),
+		StickyProxies:        parseGlobListEnv("STICKY_PROXIES"),
+		ProbeOrder:           probeOrder,
+		DelaySamples:         delaySamples,
+		DelayTrim:            delayTrim,
+		EndpointNetwork:      endpointNetwork,
+		SpeedtestBytes:       int64(speedtestBytes),
+		ScoreExpr:            scoreExpression,
+		OutputTemplate:       outputTemplate,
+		ExitIPURL:            envOrDefault("EXIT_IP_URL", "https://ifconfig.co/json"),
+		AvoidSameExitIP:      parseBoolEnv("AVOID_SAME_EXIT_IP", false),
+		AdminAddr:            strings.TrimSpace(os.Getenv("ADMIN_ADDR")),
+		AlertOnly:            parseBoolEnv("ALERT_ONLY", false),
+		EndpointHistory:      endpointHistory,
+		EndpointHistoryEvery: endpointHistoryEvery,
+		SpreadHistory:        spreadHistory,
+		SwitchStatuses:       endpointSwitchStatuses,
+		ForceStart:           parseBoolEnv("FORCE_START", false),
+		GateTimeoutS:         gateTimeoutS,
+		HistoryFile:          strings.TrimSpace(os.Getenv("HISTORY_FILE")),
+		Schedule:             schedule,
+		WatchWindow:          watchWindow,
+		PauseFile:            strings.TrimSpace(os.Getenv("PAUSE_FILE")),
+		FallbackOrder:        fallbackOrder,
+		CheckDirectBaseline:  parseBoolEnv("CHECK_DIRECT_BASELINE", false),
+		HealthPath:           strings.TrimSpace(os.Getenv("ENDPOINT_HEALTH_PATH")),
+		CacheTTLS:            cacheTTLS,
+		ProbePriority:        parseGlobListEnv("PROBE_PRIORITY"),
+		ProbeCandidateLimit:  probeCandidateLimit,
+		SkipGroupValidation:  parseBoolEnv("SKIP_GROUP_VALIDATION", false),
+		DesktopNotify:        parseBoolEnv("DESKTOP_NOTIFY", false),
+		NotifyWebhookURL:     strings.TrimSpace(os.Getenv("NOTIFY_WEBHOOK_URL")),
+		WebhookBatchS:        webhookBatchS,
+		ReportControllerLoad: parseBoolEnv("REPORT_CONTROLLER_LOAD", false),
+		ReliabilityWeight:    reliabilityWeight,
+		EventFormat:          eventFormat,
+		ResumeSkipThresholdS: resumeSkipThresholdS,
+		ExcludeRegions:       parseListEnv("EXCLUDE_REGIONS"),
+		IncludeRegions:       parseListEnv("INCLUDE_REGIONS"),
+		FilterNameRegex:      filterNameRegex,
+		DelayAggregate:       delayAggregate,
+		SwitchCooldownS:      switchCooldownS,
+		MetricsAddr:          strings.TrimSpace(os.Getenv("METRICS_ADDR")),
+		controller:           &controllerState{},
+	}, nil
+}
+
+var defaultFallbackOrder = []string{"verified", "fallback-proxy", "keep"}
+
+// parseFallbackOrder validates UNREACHABLE_FALLBACK_ORDER. "keep" ends the
+// chain and is implied when omitted.
+func parseFallbackOrder(tokens []string) ([]string, error) {
+	if len(tokens) == 0 {
+		return defaultFallbackOrder, nil
+	}
+	seen := make(map[string]bool, len(tokens))
+	order := make([]string, 0, len(tokens)+1)
+	for i, token := range tokens {
+		token = strings.ToLower(token)
+		switch token {
+		case "verified", "fallback-proxy", "keep":
+		default:
+			return nil, fmt.Errorf("UNREACHABLE_FALLBACK_ORDER must contain only verified, fallback-proxy, keep; got %q", token)
+		}
+		if seen[token] {
+			return nil, fmt.Errorf("UNREACHABLE_FALLBACK_ORDER lists %q twice", token)
+		}
+		if token == "keep" && i != len(tokens)-1 {
+			return nil, errors.New("UNREACHABLE_FALLBACK_ORDER must end with keep when it lists keep")
+		}
+		seen[token] = true
+		order = append(order, token)
+	}
+	if !seen["keep"] {
+		order = append(order, "keep")
+	}
+	return order, nil
+}
+
+// basicAuth is CONTROLLER_BASIC_USER/CONTROLLER_BASIC_PASS, for a controller
+// behind a reverse proxy that wants HTTP Basic auth instead of the secret.
+type basicAuth struct {
+	User, Pass string
+}
+
+func controllerBasicAuth(cfg Config) basicAuth {
+	return basicAuth{User: cfg.ControllerBasicUser, Pass: cfg.ControllerBasicPass}
+}
+
+// logControllerAuth notes which credentials win when both Basic auth and a
+// controller secret are configured.
+func logControllerAuth(cfg Config) {
+	if cfg.ControllerBasicUser != "" && (cfg.ControllerSecret != "" || cfg.FallbackSecret != "") {
+		logWarnf("Both CONTROLLER_BASIC_USER and MIHOMO_CONTROLLER_SECRET are set; using HTTP Basic auth as %q and ignoring the secret", cfg.ControllerBasicUser)
+	}
+}
+
+// setAuthHeader sends Basic auth when configured, otherwise the secret as a
+// Bearer token.
+func setAuthHeader(req *http.Request, secret string, basic basicAuth) {
+	if basic.User != "" {
+		req.SetBasicAuth(basic.User, basic.Pass)
+		return
+	}
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+}
+
+func toInt(value any) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return 0, false
+		}
+		return int(i), true
+	case string:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, false
+		}
+		return i, true
+	default:
+		return 0, false
+	}
+}
+
+func lookupDelayField(item map[string]any, field string) (any, bool) {
+	var current any = item
+	for _, key := range strings.Split(field, ".") {
+		obj, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current, ok = obj[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func proxyItemDelay(item map[string]any, candidates []string) (int, bool) {
+	if len(candidates) == 0 {
+		candidates = defaultDelayFieldCandidates
+	}
+	for _, field := range candidates {
+		raw, ok := lookupDelayField(item, field)
+		if !ok {
+			continue
+		}
+		if delayMS, ok := toInt(raw); ok {
+			return delayMS, true
+		}
+	}
+	return 0, false
+}
+
+// lastHistoryEntry returns the most recent entry of a proxy's "history"
+// array, as reported by the controller's /proxies shape.
+func lastHistoryEntry(item map[string]any) (map[string]any, bool) {
+	history, ok := item["history"].([]any)
+	if !ok || len(history) == 0 {
+		return nil, false
+	}
+	entry, ok := history[len(history)-1].(map[string]any)
+	return entry, ok
+}
+
+// delayTimeFields name a measurement timestamp on a proxy entry itself, for
+// controllers that report one without a history array. A generic updated_at
+// may change for reasons other than a delay test, so it is not one of them.
+var delayTimeFields = []string{"time", "timestamp", "measured_at"}
+
+// proxyItemTime returns when a proxy entry's delay was measured: the newer of
+// the first delayTimeFields value on the entry and the time of its last
+// history entry.
+func proxyItemTime(item map[string]any, last map[string]any) (time.Time, bool) {
+	measuredAt, ok := parseDelayTime(last["time"])
+	for _, field := range delayTimeFields {
+		if itemTime, hasTime := parseDelayTime(item[field]); hasTime {
+			if !ok || itemTime.After(measuredAt) {
+				measuredAt, ok = itemTime, true
+			}
+			break
+		}
+	}
+	return measuredAt, ok
+}
+
+// parseDelayTime accepts an RFC 3339 string or a Unix timestamp in seconds
+// (milliseconds when it is too large to be seconds).
+func parseDelayTime(value any) (time.Time, bool) {
+	switch v := value.(type) {
+	case float64, json.Number, int, int64:
+		unix, ok := toInt(v)
+		if !ok || unix <= 0 {
+			return time.Time{}, false
+		}
+		if unix > 1e12 {
+			return time.UnixMilli(int64(unix)), true
+		}
+		return time.Unix(int64(unix), 0), true
+	}
+	raw, ok := value.(string)
+	if !ok || raw == "" {
+		return time.Time{}, false
+	}
+	measuredAt, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return measuredAt, true
+}
+
+func isStaleDelay(measuredAt time.Time, maxAgeS int) bool {
+	return maxAgeS > 0 && time.Since(measuredAt) > time.Duration(maxAgeS)*time.Second
+}
+
+func parseGroupDelays(payload map[string]any, cfg Config) []ProxyDelay {
+	delays, _ := parseGroupDelaysCounted(payload, cfg)
+	return delays
+}
+
+// delayCounts describes a delay payload beyond the usable delays, so an empty
+// result can be explained: how many group members it listed and how many of
+// them the name filters removed.
+type delayCounts struct {
+	Members  int
+	Filtered int
+}
+
+func parseGroupDelaysCounted(payload map[string]any, cfg Config) ([]ProxyDelay, delayCounts) {
+	delays := make([]ProxyDelay, 0)
+	var counts delayCounts
+
+	if delaysRaw, ok := payload["delays"].(map[string]any); ok {
+		for name, delay := range delaysRaw {
+			counts.Members++
+			if isFilteredProxy(name, cfg) {
+				counts.Filtered++
+				continue
+			}
+			delayMS, ok := toInt(delay)
+			if entry, isEntry := delay.(map[string]any); isEntry {
+				delayMS, ok = proxyItemDelay(entry, cfg.DelayFieldCandidates)
+				if measuredAt, hasTime := proxyItemTime(entry, nil); hasTime && isStaleDelay(measuredAt, cfg.MaxDelayAgeS) {
+					continue
+				}
+			}
+			if !ok {
+				continue
+			}
+			if delayMS >= 0 {
+				delays = append(delays, ProxyDelay{Name: name, DelayMS: delayMS})
+			}
+		}
+		return delays, counts
+	}
+
+	var flat delayCounts
+	for name, delay := range payload {
+		delayMS, ok := toInt(delay)
+		if !ok {
+			continue
+		}
+		flat.Members++
+		if isFilteredProxy(name, cfg) {
+			flat.Filtered++
+			continue
+		}
+		if delayMS >= 0 {
+			delays = append(delays, ProxyDelay{Name: name, DelayMS: delayMS})
+		}
+	}
+	if len(delays) > 0 {
+		return delays, flat
+	}
+
+	if proxiesRaw, ok := payload["proxies"].([]any); ok {
+		for _, item := range proxiesRaw {
+			proxyItem, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			name, ok := proxyItem["name"].(string)
+			if !ok {
+				continue
+			}
+			counts.Members++
+			if isFilteredProxy(name, cfg) {
+				counts.Filtered++
+				continue
+			}
+			delayMS, ok := proxyItemDelay(proxyItem, cfg.DelayFieldCandidates)
+			last, hasHistory := lastHistoryEntry(proxyItem)
+			if hasHistory && !ok {
+				delayMS, ok = toInt(last["delay"])
+			}
+			if measuredAt, hasTime := proxyItemTime(proxyItem, last); hasTime && isStaleDelay(measuredAt, cfg.MaxDelayAgeS) {
+				continue
+			}
+			if !ok {
+				continue
+			}
+			if delayMS >= 0 {
+				delays = append(delays, ProxyDelay{Name: name, DelayMS: delayMS})
+			}
+		}
+		return delays, counts
+	}
+
+	name, hasName := payload["name"].(string)
+	delay, hasDelay := payload["delay"]
+	if hasName && hasDelay {
+		if isFilteredProxy(name, cfg) {
+			return []ProxyDelay{}, delayCounts{Members: 1, Filtered: 1}
+		}
+		delayMS, ok := toInt(delay)
+		if ok && delayMS >= 0 {
+			return []ProxyDelay{{Name: name, DelayMS: delayMS}}, delayCounts{Members: 1}
+		}
+		return []ProxyDelay{}, delayCounts{Members: 1}
+	}
+	if flat.Members > 0 {
+		return delays, flat
+	}
+
+	logDebugf("Unexpected delay payload shape: %v", payload)
+	return []ProxyDelay{}, counts
+}
+
+type nonJSONError struct {
+	MediaType string
+}
+
+func (e *nonJSONError) Error() string {
+	return fmt.Sprintf("controller returned non-JSON (%s); is MIHOMO_CONTROLLER_URL pointing at the API?", e.MediaType)
+}
+
+type controllerStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *controllerStatusError) Error() string {
+	return fmt.Sprintf("request failed: %s", e.Status)
+}
+
+// controllerState tracks which controller answers requests. Once the primary
+// fails and the fallback answers, the fallback stays active until the next
+// cycle resets it or the fallback itself fails and the primary answers.
+type controllerState struct {
+	mu          sync.Mutex
+	useFallback bool
+	// secrets maps a comma-separated secret list to the secret that last
+	// worked with it.
+	secrets map[string]string
+
+	// requests and requestNanos count the controller requests of the current
+	// cycle and their summed duration.
+	requests     atomic.Int64
+	requestNanos atomic.Int64
+}
+
+func (s *controllerState) fallbackActive() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.useFallback
+}
+
+func (s *controllerState) setFallbackActive(active bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.useFallback = active
+}
+
+func (s *controllerState) workingSecret(list string) string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.secrets[list]
+}
+
+func (s *controllerState) setWorkingSecret(list, secret string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.secrets == nil {
+		s.secrets = make(map[string]string)
+	}
+	s.secrets[list] = secret
+}
+
+func (s *controllerState) countRequest(elapsed time.Duration) {
+	if s == nil {
+		return
+	}
+	s.requests.Add(1)
+	s.requestNanos.Add(int64(elapsed))
+}
+
+// cycleLoad returns the number of controller requests made this cycle and
+// their summed duration in ms; concurrent requests overlap, so the time can
+// exceed the cycle's wall time.
+func (s *controllerState) cycleLoad() (int, int) {
+	return int(s.requests.Load()), int(time.Duration(s.requestNanos.Load()).Milliseconds())
+}
+
+func (s *controllerState) resetCycle() {
+	s.setFallbackActive(false)
+	if s != nil {
+		s.requests.Store(0)
+		s.requestNanos.Store(0)
+	}
+}
+
+// isFailoverError reports whether a failed request to endpoint points at the
+// controller itself: a connection error or a 5xx status. The 503/504 that
+// mihomo returns from a delay test when the node times out is about the node,
+// so it neither fails over nor is retried.
+func isFailoverError(endpoint string, err error) bool {
+	var statusErr *controllerStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 && !isDelayProbeFailure(endpoint, statusErr.StatusCode)
+	}
+	var nonJSON *nonJSONError
+	return !errors.As(err, &nonJSON)
+}
+
+// isDelayProbeFailure reports whether status is a delay endpoint's answer for
+// a node that failed the test (/proxies/{name}/delay, /group/{name}/delay).
+func isDelayProbeFailure(endpoint string, status int) bool {
+	if status != http.StatusServiceUnavailable && status != http.StatusGatewayTimeout {
+		return false
+	}
+	u, err := url.Parse(endpoint)
+	return err == nil && strings.HasSuffix(u.Path, "/delay")
+}
+
+func controllerRequest(ctx context.Context, client *http.Client, cfg Config, method, endpoint string, body []byte) (map[string]any, error) {
+	return controllerRequestWithTimeout(ctx, client, cfg, method, endpoint, body, 0)
+}
+
+// delayRequestTimeout bounds a delay call to the controller: the probe timeout
+// passed in the query plus slack for the controller's own overhead.
+func delayRequestTimeout(timeoutMS int) time.Duration {
+	return time.Duration(timeoutMS)*time.Millisecond + delayRequestSlack
+}
+
+// controllerRequestWithTimeout is controllerRequest with a deadline applied to
+// each attempt; timeout <= 0 means no deadline beyond the client's own.
+// Connection errors and 5xx responses are retried CONTROLLER_RETRIES times
+// with exponential backoff. No retry is started that would end past ctx's
+// deadline (RUN_TIMEOUT_MS within a run), or, without one, one
+// MONITOR_INTERVAL_S after the first attempt, so a dead controller cannot
+// stall the monitor loop.
+func controllerRequestWithTimeout(ctx context.Context, client *http.Client, cfg Config, method, endpoint string, body []byte, timeout time.Duration) (map[string]any, error) {
+	payload, err := controllerRequestOnce(ctx, client, cfg, method, endpoint, body, timeout)
+	if err == nil || cfg.ControllerRetries <= 0 {
+		return payload, err
+	}
+	deadline, bounded := ctx.Deadline()
+	if !bounded && cfg.MonitorIntervalS > 0 {
+		deadline, bounded = time.Now().Add(time.Duration(cfg.MonitorIntervalS)*time.Second), true
+	}
+	backoff := time.Duration(cfg.ControllerBackoffMS) * time.Millisecond
+	for attempt := 1; attempt <= cfg.ControllerRetries && isFailoverError(endpoint, err); attempt++ {
+		if bounded && time.Now().Add(backoff).After(deadline) {
+			logDebugf("Controller request %s %s failed (%v); no time left to retry before the next cycle", method, redactURLPassword(endpoint), err)
+			break
+		}
+		logDebugf("Controller request %s %s failed (%v); retry %d/%d in %s", method, redactURLPassword(endpoint), err, attempt, cfg.ControllerRetries, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		payload, err = controllerRequestOnce(ctx, client, cfg, method, endpoint, body, timeout)
+		if err == nil {
+			return payload, nil
+		}
+		backoff *= 2
+	}
+	return nil, err
+}
+
+func controllerRequestOnce(ctx context.Context, client *http.Client, cfg Config, method, endpoint string, body []byte, timeout time.Duration) (map[string]any, error) {
+	if cfg.ControllerFallback == "" || !strings.HasPrefix(endpoint, cfg.ControllerURL) {
+		return doControllerRequestAuth(ctx, client, cfg.controller, controllerBasicAuth(cfg), cfg.ControllerSecret, method, endpoint, body, timeout)
+	}
+
+	path := strings.TrimPrefix(endpoint, cfg.ControllerURL)
+	fallbackSecret := cfg.FallbackSecret
+	if fallbackSecret == "" {
+		fallbackSecret = cfg.ControllerSecret
+	}
+	bases := []string{cfg.ControllerURL, cfg.ControllerFallback}
+	secrets := []string{cfg.ControllerSecret, fallbackSecret}
+	if cfg.controller.fallbackActive() {
+		bases[0], bases[1] = bases[1], bases[0]
+		secrets[0], secrets[1] = secrets[1], secrets[0]
+	}
+
+	payload, err := doControllerRequestAuth(ctx, client, cfg.controller, controllerBasicAuth(cfg), secrets[0], method, bases[0]+path, body, timeout)
+	if err == nil || !isFailoverError(endpoint, err) {
+		return payload, err
+	}
+	payload, retryErr := doControllerRequestAuth(ctx, client, cfg.controller, controllerBasicAuth(cfg), secrets[1], method, bases[1]+path, body, timeout)
+	if retryErr != nil {
+		return nil, err
+	}
+	useFallback := bases[1] == cfg.ControllerFallback
+	if useFallback {
+		logWarnf("Controller %s failed (%v); using fallback controller %s", bases[0], err, bases[1])
+	} else {
+		logInfof("Controller fallback %s failed (%v); primary controller %s is active again", bases[0], err, bases[1])
+	}
+	cfg.controller.setFallbackActive(useFallback)
+	return payload, nil
+}
+
+// doControllerRequestAuth sends the request with each secret of the
+// comma-separated list in turn, moving on after 401 or 403, and remembers the
+// secret that worked so later requests try it first. This lets a monitor ride
+// out a secret rotation with both the old and the new secret configured.
+func doControllerRequestAuth(ctx context.Context, client *http.Client, state *controllerState, basic basicAuth, secretList, method, endpoint string, body []byte, timeout time.Duration) (map[string]any, error) {
+	secrets := strings.Split(secretList, ",")
+	for i := range secrets {
+		secrets[i] = strings.TrimSpace(secrets[i])
+	}
+	if len(secrets) == 1 || basic.User != "" {
+		return doCountedRequest(ctx, client, state, basic, secrets[0], method, endpoint, body, timeout)
+	}
+	working := state.workingSecret(secretList)
+	order := make([]int, 0, len(secrets))
+	for i, secret := range secrets {
+		if secret == working {
+			order = append([]int{i}, order...)
+		} else {
+			order = append(order, i)
+		}
+	}
+	var err error
+	for _, i := range order {
+		var payload map[string]any
+		payload, err = doCountedRequest(ctx, client, state, basic, secrets[i], method, endpoint, body, timeout)
+		var statusErr *controllerStatusError
+		if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden) {
+			continue
+		}
+		if err == nil && secrets[i] != working {
+			if working != "" || i > 0 {
+				logInfof("Controller accepted secret #%d of %d", i+1, len(secrets))
+			}
+			state.setWorkingSecret(secretList, secrets[i])
+		}
+		return payload, err
+	}
+	return nil, err
+}
+
+func doCountedRequest(ctx context.Context, client *http.Client, state *controllerState, basic basicAuth, secret, method, endpoint string, body []byte, timeout time.Duration) (map[string]any, error) {
+	start := time.Now()
+	defer func() { state.countRequest(time.Since(start)) }()
+	return doControllerRequest(ctx, client, basic, secret, method, endpoint, body, timeout)
+}
+
+func doControllerRequest(ctx context.Context, client *http.Client, basic basicAuth, secret, method, endpoint string, body []byte, timeout time.Duration) (map[string]any, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var reader *bytes.Reader
+	if body == nil {
+		reader = bytes.NewReader([]byte{})
+	} else {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, err
+	}
+	setAuthHeader(req, secret, basic)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, &controllerStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if resp.StatusCode == http.StatusNoContent || resp.ContentLength == 0 {
+		return map[string]any{}, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		return nil, &nonJSONError{MediaType: mediaType}
+	}
+	var payload map[string]any
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			return map[string]any{}, nil
+		}
+		if mediaType != "" && !strings.HasSuffix(mediaType, "json") {
+			return nil, &nonJSONError{MediaType: mediaType}
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+func getGroupDelaysWithFilter(client *http.Client, cfg Config, filterHKNodes bool) []ProxyDelay {
+	delays, _ := getGroupDelaysWithReason(client, cfg, filterHKNodes)
+	return delays
+}
+
+// Reason codes for an empty delay list, reported as reason_code.
+const (
+	noDataControllerUnreachable = "controller_unreachable"
+	noDataControllerError       = "controller_error"
+	noDataGroupEmpty            = "group_empty"
+	noDataAllFiltered           = "all_filtered"
+	noDataAllTimedOut           = "all_timed_out"
+)
+
+var noDataMessages = map[string]string{
+	noDataControllerUnreachable: "controller unreachable",
+	noDataControllerError:       "controller returned an error",
+	noDataGroupEmpty:            "group has no members",
+	noDataAllFiltered:           "all nodes were filtered out",
+	noDataAllTimedOut:           "all nodes timed out",
+}
+
+// noDataReason explains why a delay fetch produced no delays.
+func noDataReason(counts delayCounts, err error) string {
+	var statusErr *controllerStatusError
+	var nonJSON *nonJSONError
+	switch {
+	case errors.As(err, &statusErr), errors.As(err, &nonJSON):
+		return noDataControllerError
+	case err != nil:
+		return noDataControllerUnreachable
+	case counts.Members == 0:
+		return noDataGroupEmpty
+	case counts.Filtered == counts.Members:
+		return noDataAllFiltered
+	default:
+		return noDataAllTimedOut
+	}
+}
+
+// noDataResult is the result object of a command that got no delays.
+func noDataResult(reason string) map[string]any {
+	return map[string]any{"error": "no delay data", "reason_code": reason, "message": noDataMessages[reason]}
+}
+
+func noDataText(reason string) string {
+	return fmt.Sprintf("No delay data returned (%s)", noDataMessages[reason])
+}
+
+// refreshGroupHealth is REFRESH_BEFORE_READ: for URLTest, Fallback and
+// LoadBalance groups it triggers the controller's health check of the group
+// and waits refreshGrace, so the controller's own delays and selection are
+// fresh when they are read. Selector groups are left alone. groupType is the
+// group's type if the caller already has it; "" looks it up.
+func refreshGroupHealth(ctx context.Context, client *http.Client, cfg Config, groupType string) {
+	if !cfg.RefreshBeforeRead {
+		return
+	}
+	if groupType == "" {
+		info, err := getGroupInfo(ctx, client, cfg)
+		if err != nil {
+			logWarnf("REFRESH_BEFORE_READ: group lookup failed: %v", err)
+			return
+		}
+		groupType = info.Type
+	}
+	if !isAutoManagedGroup(groupType) {
+		return
+	}
+	endpoint := fmt.Sprintf("%s/providers/proxies/%s/healthcheck", cfg.ControllerURL, url.PathEscape(cfg.ProxyGroup))
+	if _, err := controllerRequestWithTimeout(ctx, client, cfg, http.MethodGet, endpoint, nil, delayRequestTimeout(cfg.DelayTimeoutMS)); err != nil {
+		logWarnf("REFRESH_BEFORE_READ: health check of %s failed: %v", cfg.ProxyGroup, err)
+		return
+	}
+	select {
+	case <-time.After(refreshGrace):
+	case <-ctx.Done():
+	}
+}
+
+// getGroupDelaysWithReason returns the group delays and, when there are none,
+// the reason code explaining why.
+func getGroupDelaysWithReason(client *http.Client, cfg Config, filterHKNodes bool) ([]ProxyDelay, string) {
+	return readGroupDelays(context.Background(), client, cfg).delays(cfg, filterHKNodes)
+}
+
+// groupDelayRead is one read of the group delays: the unfiltered answer of
+// every DELAY_SAMPLES sample for every TEST_URLS target. Filtered candidates
+// and the unfiltered current-node lookup are both parsed from it in memory,
+// so a cycle runs DELAY_SAMPLES group tests however many views it needs.
+type groupDelayRead struct {
+	samples [][]targetPayload
+}
+
+// targetPayload is the controller's answer for one test URL.
+type targetPayload struct {
+	url     string
+	payload map[string]any
+	err     error
+}
+
+// readGroupDelays takes the DELAY_SAMPLES samples of the group delays.
+func readGroupDelays(ctx context.Context, client *http.Client, cfg Config) groupDelayRead {
+	samples := cfg.DelaySamples
+	if samples < 1 {
+		samples = 1
+	}
+	read := groupDelayRead{samples: make([][]targetPayload, 0, samples)}
+	for i := 0; i < samples; i++ {
+		sample := fetchGroupPayloads(ctx, client, cfg)
+		if err := sampleError(sample); err != nil {
+			if samples > 1 {
+				logWarnf("Group delay check failed (sample %d/%d): %v", i+1, samples, err)
+			} else {
+				logWarnf("Group delay check failed: %v", err)
+			}
+		}
+		read.samples = append(read.samples, sample)
+	}
+	return read
+}
+
+// sampleError is the last target error of a sample in which no target
+// answered, or nil.
+func sampleError(sample []targetPayload) error {
+	var lastErr error
+	for _, target := range sample {
+		if target.err == nil {
+			return nil
+		}
+		lastErr = target.err
+	}
+	return lastErr
+}
+
+// delays parses the read with cfg's name filters, FILTER_HK_NODES set to
+// filterHKNodes, and aggregates each node's samples by DELAY_AGGREGATE. When
+// no delay is left, the reason code says why.
+func (r groupDelayRead) delays(cfg Config, filterHKNodes bool) ([]ProxyDelay, string) {
+	cfg.FilterHKNodes = filterHKNodes
+	order := make([]string, 0)
+	samples := make(map[string][]int)
+	reason := ""
+	succeeded := 0
+	for _, sample := range r.samples {
+		parsed, counts, err := mergeTargetDelays(sample, cfg)
+		if err != nil {
+			reason = noDataReason(counts, err)
+			continue
+		}
+		succeeded++
+		if len(parsed) == 0 {
+			reason = noDataReason(counts, nil)
+		}
+		for _, item := range parsed {
+			if _, seen := samples[item.Name]; !seen {
+				order = append(order, item.Name)
+			}
+			samples[item.Name] = append(samples[item.Name], item.DelayMS)
+		}
+	}
+	delays := make([]ProxyDelay, 0, len(order))
+	for _, name := range order {
+		delays = append(delays, ProxyDelay{
+			Name:    name,
+			DelayMS: aggregateSamples(samples[name], cfg),
+			Loss:    1 - float64(len(samples[name]))/float64(succeeded),
+		})
+	}
+	sortDelays(delays)
+	if len(delays) > 0 {
+		reason = ""
+	}
+	return delays, reason
+}
+
+// all parses the read without any name filter, for looking up the current
+// proxy's delay, which must be found whatever its name.
+func (r groupDelayRead) all(cfg Config) ([]ProxyDelay, string) {
+	cfg.FilterInfoNodes = false
+	cfg.FilterNameRegex = nil
+	return r.delays(cfg, false)
+}
+
+func testURLs(cfg Config) []string {
+	if len(cfg.TestURLs) == 0 {
+		return []string{cfg.TestURL}
+	}
+	return cfg.TestURLs
+}
+
+// fetchGroupDelays queries the group delay API once per test URL, at most
+// testURLConcurrency at a time, and keeps each proxy's worst delay. Proxies
+// missing from any answered URL are dropped; a URL whose request fails is
+// logged and left out of the merge.
+func fetchGroupDelays(client *http.Client, cfg Config) ([]ProxyDelay, error) {
+	delays, _, err := mergeTargetDelays(fetchGroupPayloads(context.Background(), client, cfg), cfg)
+	return delays, err
+}
+
+// fetchGroupPayloads requests the group delay test once per test URL, at
+// most testURLConcurrency at a time, and returns the raw answers in URL
+// order. With several URLs, each failed one is logged.
+func fetchGroupPayloads(ctx context.Context, client *http.Client, cfg Config) []targetPayload {
+	targets := testURLs(cfg)
+	results := make([]targetPayload, len(targets))
+	sem := make(chan struct{}, testURLConcurrency)
+	var wg sync.WaitGroup
+	for idx, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			endpoint := fmt.Sprintf("%s/group/%s/delay", cfg.ControllerURL, url.PathEscape(cfg.ProxyGroup))
+			params := url.Values{}
+			params.Set("url", target)
+			params.Set("timeout", strconv.Itoa(cfg.DelayTimeoutMS))
+			payload, err := controllerRequestWithTimeout(ctx, client, cfg, http.MethodGet, endpoint+"?"+params.Encode(), nil, delayRequestTimeout(cfg.DelayTimeoutMS))
+			results[i] = targetPayload{url: target, payload: payload, err: err}
+		}(idx, target)
+	}
+	wg.Wait()
+	if len(targets) > 1 {
+		for _, result := range results {
+			if result.err != nil {
+				logWarnf("Group delay check for %s failed: %v", result.url, result.err)
+			}
+		}
+	}
+	return results
+}
+
+// mergeTargetDelays parses each answered test URL with cfg's filters and
+// keeps each proxy's worst delay, dropping proxies missing from any of them.
+// The counts are those of the first URL that answered.
+func mergeTargetDelays(targets []targetPayload, cfg Config) ([]ProxyDelay, delayCounts, error) {
+	var merged []ProxyDelay
+	var mergedCounts delayCounts
+	var lastErr error
+	for _, target := range targets {
+		if target.err != nil {
+			lastErr = target.err
+			continue
+		}
+		delays, counts := parseGroupDelaysCounted(target.payload, cfg)
+		if merged == nil {
+			merged, mergedCounts = delays, counts
+			continue
+		}
+		byName := make(map[string]int, len(delays))
+		for _, item := range delays {
+			byName[item.Name] = item.DelayMS
+		}
+		kept := merged[:0:0]
+		for _, item := range merged {
+			delay, ok := byName[item.Name]
+			if !ok {
+				continue
+			}
+			if delay > item.DelayMS {
+				item.DelayMS = delay
+			}
+			kept = append(kept, item)
+		}
+		merged = kept
+	}
+	if merged == nil {
+		return nil, delayCounts{}, lastErr
+	}
+	sortDelays(merged)
+	return merged, mergedCounts, nil
+}
+
+// aggregateSamples combines one node's DELAY_SAMPLES by DELAY_AGGREGATE.
+func aggregateSamples(values []int, cfg Config) int {
+	if cfg.DelayAggregate == "median" {
+		return medianSample(values)
+	}
+	return trimmedMean(values, cfg.DelayTrim)
+}
+
+// medianSample is the middle value of values, or the rounded mean of the two
+// middle values for an even count.
+func medianSample(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]int, len(values))
+	copy(sorted, values)
+	sort.Ints(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return int(math.Round(float64(sorted[mid-1]+sorted[mid]) / 2))
+}
+
+// trimmedMean drops the trim highest and lowest values before averaging. trim
+// is reduced when there are too few values to keep at least one.
+func trimmedMean(values []int, trim int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]int, len(values))
+	copy(sorted, values)
+	sort.Ints(sorted)
+	if maxTrim := (len(sorted) - 1) / 2; trim > maxTrim {
+		trim = maxTrim
+	}
+	kept := sorted[trim : len(sorted)-trim]
+	sum := 0
+	for _, v := range kept {
+		sum += v
+	}
+	return int(math.Round(float64(sum) / float64(len(kept))))
 }
 
 func getGroupDelays(client *http.Client, cfg Config) []ProxyDelay {
 	return getGroupDelaysWithFilter(client, cfg, cfg.FilterHKNodes)
 }
 
+// getAllGroupDelays skips every name filter; it is used to look up the
+// current proxy's delay, which must be found whatever its name.
+func getAllGroupDelays(client *http.Client, cfg Config) []ProxyDelay {
+	delays, _ := getAllGroupDelaysWithReason(client, cfg)
+	return delays
+}
+
+func getAllGroupDelaysWithReason(client *http.Client, cfg Config) ([]ProxyDelay, string) {
+	return readGroupDelays(context.Background(), client, cfg).all(cfg)
+}
+
+// builtinProxies are the controller's built-in outbounds and the GLOBAL
+// group, which group delay results may list alongside real nodes.
+var builtinProxies = []string{"DIRECT", "REJECT", "REJECT-DROP", "PASS", "COMPATIBLE", "GLOBAL"}
+
+func isBuiltinProxy(name string) bool {
+	for _, builtin := range builtinProxies {
+		if strings.EqualFold(name, builtin) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutBuiltinProxies drops built-in outbounds so they are never picked as
+// switch candidates.
+func withoutBuiltinProxies(delays []ProxyDelay) []ProxyDelay {
+	out := make([]ProxyDelay, 0, len(delays))
+	for _, item := range delays {
+		if !isBuiltinProxy(item.Name) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
 func findBestAlternative(delays []ProxyDelay, current string) (ProxyDelay, bool) {
 	for _, item := range delays {
 		if item.Name != current {
@@ -347,58 +1853,423 @@ func findBestAlternative(delays []ProxyDelay, current string) (ProxyDelay, bool)
 	return ProxyDelay{}, false
 }
 
-func getProxyDelay(client *http.Client, cfg Config, proxyName, targetURL string, timeoutMS int) (int, bool) {
-	endpoint := fmt.Sprintf("%s/proxies/%s/delay", cfg.ControllerURL, url.PathEscape(proxyName))
-	params := url.Values{}
-	params.Set("url", targetURL)
-	params.Set("timeout", strconv.Itoa(timeoutMS))
-	endpoint = endpoint + "?" + params.Encode()
-
-	payload, err := controllerRequest(client, cfg, http.MethodGet, endpoint, nil)
-	if err != nil {
-		return -1, false
+func medianDelay(delays []ProxyDelay) float64 {
+	if len(delays) == 0 {
+		return 0
+	}
+	values := make([]ProxyDelay, len(delays))
+	copy(values, delays)
+	sortDelays(values)
+	mid := len(values) / 2
+	if len(values)%2 == 1 {
+		return float64(values[mid].DelayMS)
+	}
+	return float64(values[mid-1].DelayMS+values[mid].DelayMS) / 2
+}
+
+// orderCandidates returns delays in the order switch candidates should be
+// tried for cfg.SelectStrategy. delays must already be sorted by delay.
+func orderCandidates(delays []ProxyDelay, current string, cfg Config) []ProxyDelay {
+	if cfg.SelectStrategy == "spread" {
+		return spreadCandidates(delays, current, cfg)
+	}
+	if cfg.SelectStrategy == "reliable" {
+		return reliableCandidates(delays, current, cfg)
+	}
+	if cfg.SelectStrategy != "median" {
+		return delays
+	}
+	acceptable := make([]ProxyDelay, 0, len(delays))
+	rest := make([]ProxyDelay, 0)
+	for _, item := range delays {
+		if item.Name == current {
+			continue
+		}
+		if item.DelayMS <= cfg.KeepDelayThresholdMS {
+			acceptable = append(acceptable, item)
+		} else {
+			rest = append(rest, item)
+		}
+	}
+	if len(acceptable) == 0 {
+		acceptable, rest = rest, acceptable
+	}
+
+	median := medianDelay(acceptable)
+	distance := func(item ProxyDelay) float64 {
+		d := float64(item.DelayMS) - median
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	for i := 1; i < len(acceptable); i++ {
+		j := i
+		for j > 0 && distance(acceptable[j-1]) > distance(acceptable[j]) {
+			acceptable[j-1], acceptable[j] = acceptable[j], acceptable[j-1]
+			j--
+		}
+	}
+	return append(acceptable, rest...)
+}
+
+// scoreCandidates orders delays by ascending SCORE_EXPR score, ties going to
+// the faster node. endpoint_latency is the mean controller-measured latency to
+// ENDPOINT_URLS, only measured when the expression uses it and only for the
+// probe window; unreachable endpoints and unmeasured nodes count as
+// DELAY_TIMEOUT_MS.
+func scoreCandidates(ctx context.Context, client *http.Client, cfg Config, delays []ProxyDelay, current string) []ProxyDelay {
+	endpointLatency := make(map[string]float64)
+	if cfg.ScoreExpr.uses("endpoint_latency") && len(cfg.EndpointURLs) > 0 {
+		batchSize := cfg.ProbeConcurrency
+		if batchSize <= 0 {
+			batchSize = defaultProbeConcurrency
+		}
+		window := probeWindow(delays, current, cfg)
+		for start := 0; start < len(window); start += batchSize {
+			batch := window[start:min(start+batchSize, len(window))]
+			latencies := make([]float64, len(batch))
+			var wg sync.WaitGroup
+			for idx, item := range batch {
+				wg.Add(1)
+				go func(i int, name string) {
+					defer wg.Done()
+					total := 0
+					for _, result := range verifyProxyEndpoints(ctx, client, cfg, name, cfg.EndpointURLs) {
+						if result.Reachable {
+							total += result.LatencyMS
+						} else {
+							total += cfg.DelayTimeoutMS
+						}
+					}
+					latencies[i] = float64(total) / float64(len(cfg.EndpointURLs))
+				}(idx, item.Name)
+			}
+			wg.Wait()
+			for idx, item := range batch {
+				endpointLatency[item.Name] = latencies[idx]
+			}
+		}
+	}
+
+	type scored struct {
+		item  ProxyDelay
+		score float64
+	}
+	ranked := make([]scored, 0, len(delays))
+	for _, item := range delays {
+		if item.Name == current {
+			continue
+		}
+		latency, ok := endpointLatency[item.Name]
+		if !ok && len(cfg.EndpointURLs) > 0 {
+			latency = float64(cfg.DelayTimeoutMS)
+		}
+		score := cfg.ScoreExpr.eval(map[string]float64{
+			"delay":            float64(item.DelayMS),
+			"loss":             item.Loss,
+			"endpoint_latency": latency,
+		})
+		if math.IsNaN(score) {
+			score = math.Inf(1)
+		}
+		ranked = append(ranked, scored{item: item, score: score})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score < ranked[j].score
+	})
+	out := make([]ProxyDelay, 0, len(ranked))
+	for _, entry := range ranked {
+		out = append(out, entry.item)
+	}
+	return out
+}
+
+// spreadCandidates orders acceptable alternatives (delay <= keep threshold,
+// or all if none are) so that nodes from countries not among the current
+// node's and cfg.recentRegions come first, then nodes of unknown country, then
+// recently used countries, each group fastest first. Unacceptable nodes come
+// last.
+func spreadCandidates(delays []ProxyDelay, current string, cfg Config) []ProxyDelay {
+	avoid := make(map[string]bool)
+	if region := inferRegion(current); region != "" {
+		avoid[region] = true
+	}
+	for _, region := range cfg.recentRegions {
+		avoid[region] = true
+	}
+
+	threshold := math.MaxInt
+	for _, item := range delays {
+		if item.Name != current && item.DelayMS <= cfg.KeepDelayThresholdMS {
+			threshold = cfg.KeepDelayThresholdMS
+			break
+		}
+	}
+
+	var fresh, unknown, recent, rest []ProxyDelay
+	for _, item := range delays {
+		if item.Name == current {
+			continue
+		}
+		if item.DelayMS > threshold {
+			rest = append(rest, item)
+			continue
+		}
+		switch region := inferRegion(item.Name); {
+		case region == "":
+			unknown = append(unknown, item)
+		case avoid[region]:
+			recent = append(recent, item)
+		default:
+			fresh = append(fresh, item)
+		}
+	}
+	ordered := make([]ProxyDelay, 0, len(delays))
+	ordered = append(ordered, fresh...)
+	ordered = append(ordered, unknown...)
+	ordered = append(ordered, recent...)
+	return append(ordered, rest...)
+}
+
+func getProxyDelay(ctx context.Context, client *http.Client, cfg Config, proxyName, targetURL string, timeoutMS int) (int, bool) {
+	endpoint := fmt.Sprintf("%s/proxies/%s/delay", cfg.ControllerURL, url.PathEscape(proxyName))
+	params := url.Values{}
+	params.Set("url", targetURL)
+	params.Set("timeout", strconv.Itoa(timeoutMS))
+	endpoint = endpoint + "?" + params.Encode()
+
+	payload, err := controllerRequestWithTimeout(ctx, client, cfg, http.MethodGet, endpoint, nil, delayRequestTimeout(timeoutMS))
+	if err != nil {
+		return -1, false
+	}
+	delayRaw, ok := payload["delay"]
+	if !ok {
+		return -1, false
+	}
+	delayMS, ok := toInt(delayRaw)
+	if !ok || delayMS < 0 {
+		return -1, false
+	}
+	return delayMS, true
+}
+
+func isProxyReachableForEndpoints(ctx context.Context, client *http.Client, cfg Config, proxyName string, endpointURLs []string) bool {
+	if len(endpointURLs) == 0 {
+		return true
+	}
+	required := cfg.EndpointQuorum.required(len(endpointURLs))
+	reached, failed := 0, 0
+	for _, target := range endpointURLs {
+		target, _ = resolveEndpointURL(target, cfg)
+		if _, ok := getProxyDelay(ctx, client, cfg, proxyName, target, cfg.DelayTimeoutMS); ok {
+			reached++
+		} else {
+			failed++
+		}
+		if reached >= required {
+			return true
+		}
+		if failed > len(endpointURLs)-required {
+			return false
+		}
+	}
+	return reached >= required
+}
+
+// endpointQuorum is ENDPOINT_QUORUM: how many endpoints must pass for a node
+// to count as reachable, as a count or a percentage. The zero value requires
+// all of them.
+type endpointQuorum struct {
+	Count   int
+	Percent int
+}
+
+func parseEndpointQuorum(raw string) (endpointQuorum, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.EqualFold(raw, "all") {
+		return endpointQuorum{}, nil
+	}
+	if pct, ok := strings.CutSuffix(raw, "%"); ok {
+		percent, err := strconv.Atoi(strings.TrimSpace(pct))
+		if err != nil || percent < 1 || percent > 100 {
+			return endpointQuorum{}, fmt.Errorf("ENDPOINT_QUORUM percentage must be between 1%% and 100%%; got %q", raw)
+		}
+		return endpointQuorum{Percent: percent}, nil
+	}
+	count, err := strconv.Atoi(raw)
+	if err != nil || count < 1 {
+		return endpointQuorum{}, fmt.Errorf("ENDPOINT_QUORUM must be all, a count >= 1, or a percentage such as 50%%; got %q", raw)
+	}
+	return endpointQuorum{Count: count}, nil
+}
+
+// required is the number of passing endpoints out of total that satisfies
+// the quorum; a count larger than total requires all.
+func (q endpointQuorum) required(total int) int {
+	switch {
+	case q.Count > 0 && q.Count < total:
+		return q.Count
+	case q.Percent > 0:
+		return max(1, (total*q.Percent+99)/100)
+	}
+	return total
+}
+
+// verifyProxyEndpoints probes each endpoint through the named proxy using the
+// controller's per-proxy delay API, so any group member can be checked
+// without switching to it.
+func verifyProxyEndpoints(ctx context.Context, client *http.Client, cfg Config, proxyName string, endpointURLs []string) []EndpointResult {
+	results := make([]EndpointResult, len(endpointURLs))
+	var wg sync.WaitGroup
+	for idx, endpoint := range endpointURLs {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			requestURL, _ := resolveEndpointURL(target, cfg)
+			delayMS, ok := getProxyDelay(ctx, client, cfg, proxyName, requestURL, cfg.DelayTimeoutMS)
+			results[i] = EndpointResult{URL: target, Reachable: ok, LatencyMS: delayMS}
+		}(idx, endpoint)
+	}
+	wg.Wait()
+	return results
+}
+
+func findBestReachableAlternative(ctx context.Context, client *http.Client, cfg Config, delays []ProxyDelay, current string, endpointURLs []string) (ProxyDelay, bool) {
+	return probeReachableAlternative(ctx, client, cfg, delays, current, endpointURLs, nil)
+}
+
+// probeReachableAlternative records every endpoint verification outcome in
+// probed (when non-nil) so callers can report them without probing again.
+// Candidates are verified PROBE_CONCURRENCY at a time in probe order, and the
+// first reachable one in that order wins.
+func probeReachableAlternative(ctx context.Context, client *http.Client, cfg Config, delays []ProxyDelay, current string, endpointURLs []string, probed map[string]bool) (ProxyDelay, bool) {
+	if len(endpointURLs) == 0 {
+		return findBestAlternative(delays, current)
+	}
+	batchSize := cfg.ProbeConcurrency
+	if batchSize <= 0 {
+		batchSize = defaultProbeConcurrency
+	}
+	window := probeWindow(delays, current, cfg)
+	for start := 0; start < len(window); start += batchSize {
+		batch := window[start:min(start+batchSize, len(window))]
+		reachable := make([]bool, len(batch))
+		var wg sync.WaitGroup
+		for idx, item := range batch {
+			if known, ok := probed[item.Name]; ok {
+				reachable[idx] = known
+				continue
+			}
+			wg.Add(1)
+			go func(i int, name string) {
+				defer wg.Done()
+				reachable[i] = isProxyReachableForEndpoints(ctx, client, cfg, name, endpointURLs)
+			}(idx, item.Name)
+		}
+		wg.Wait()
+		for idx, item := range batch {
+			if probed != nil {
+				probed[item.Name] = reachable[idx]
+			}
+		}
+		for idx, item := range batch {
+			if reachable[idx] {
+				return item, true
+			}
+		}
+	}
+	return ProxyDelay{}, false
+}
+
+// probeWindow returns the ENDPOINT_PROBE_CANDIDATE_LIMIT alternatives eligible
+// for endpoint probing in probe order. With PROBE_ORDER=round-robin the window is rotated by the monitor
+// cycle count so the same leading candidates are not probed first every time.
+func probeWindow(delays []ProxyDelay, current string, cfg Config) []ProxyDelay {
+	limit := cfg.ProbeCandidateLimit
+	if limit <= 0 {
+		limit = defaultProbeCandidateLimit
 	}
-	delayRaw, ok := payload["delay"]
-	if !ok {
-		return -1, false
+	window := make([]ProxyDelay, 0, limit)
+	for _, item := range delays {
+		if item.Name == current {
+			continue
+		}
+		if len(window) >= limit {
+			break
+		}
+		window = append(window, item)
 	}
-	delayMS, ok := toInt(delayRaw)
-	if !ok || delayMS < 0 {
-		return -1, false
+	if cfg.ProbeOrder != "round-robin" || len(window) == 0 {
+		return window
 	}
-	return delayMS, true
+	start := cfg.probeOffset % len(window)
+	rotated := make([]ProxyDelay, 0, len(window))
+	rotated = append(rotated, window[start:]...)
+	return append(rotated, window[:start]...)
 }
 
-func isProxyReachableForEndpoints(client *http.Client, cfg Config, proxyName string, endpointURLs []string) bool {
-	if len(endpointURLs) == 0 {
-		return true
+// probePriority measures the current node and then PROBE_PRIORITY matches
+// (pattern order, then group order) one by one with the per-proxy delay API,
+// stopping at the first node within the keep threshold that also passes the
+// controller-based endpoint check. It returns the measured nodes worth
+// deciding on, sorted, and the number of delay probes made; delays is nil when
+// no node qualified.
+func probePriority(ctx context.Context, client *http.Client, cfg Config, current string, members []string, probed map[string]bool) ([]ProxyDelay, int) {
+	probes := 1
+	measured := make([]ProxyDelay, 0, 2)
+	if delayMS, ok := getProxyDelay(ctx, client, cfg, current, cfg.TestURL, cfg.DelayTimeoutMS); ok {
+		measured = append(measured, ProxyDelay{Name: current, DelayMS: delayMS})
+		if delayMS <= cfg.KeepDelayThresholdMS {
+			return measured, probes
+		}
 	}
-	for _, target := range endpointURLs {
-		if _, ok := getProxyDelay(client, cfg, proxyName, target, cfg.DelayTimeoutMS); !ok {
-			return false
+	seen := map[string]bool{current: true}
+	for _, pattern := range cfg.ProbePriority {
+		for _, name := range members {
+			if seen[name] || !pattern.MatchString(name) || isFilteredProxy(name, cfg) {
+				continue
+			}
+			seen[name] = true
+			probes++
+			delayMS, ok := getProxyDelay(ctx, client, cfg, name, cfg.TestURL, cfg.DelayTimeoutMS)
+			if !ok || delayMS > cfg.KeepDelayThresholdMS {
+				continue
+			}
+			reachable := isProxyReachableForEndpoints(ctx, client, cfg, name, cfg.EndpointURLs)
+			if len(cfg.EndpointURLs) > 0 {
+				probed[name] = reachable
+			}
+			if reachable {
+				measured = append(measured, ProxyDelay{Name: name, DelayMS: delayMS})
+				sortDelays(measured)
+				return measured, probes
+			}
 		}
 	}
-	return true
+	return nil, probes
 }
 
-func findBestReachableAlternative(client *http.Client, cfg Config, delays []ProxyDelay, current string, endpointURLs []string) (ProxyDelay, bool) {
-	if len(endpointURLs) == 0 {
-		return findBestAlternative(delays, current)
-	}
-	checked := 0
+func topAlternatives(delays []ProxyDelay, current string, probed map[string]bool, limit int) []map[string]any {
+	alternatives := make([]map[string]any, 0, limit)
 	for _, item := range delays {
 		if item.Name == current {
 			continue
 		}
-		if checked >= endpointProbeCandidateLimit {
+		if len(alternatives) >= limit {
 			break
 		}
-		checked++
-		if isProxyReachableForEndpoints(client, cfg, item.Name, endpointURLs) {
-			return item, true
+		var verified any
+		if reachable, ok := probed[item.Name]; ok {
+			verified = reachable
 		}
+		alternatives = append(alternatives, map[string]any{
+			"name":              item.Name,
+			"delay_ms":          item.DelayMS,
+			"endpoint_verified": verified,
+		})
 	}
-	return ProxyDelay{}, false
+	return alternatives
 }
 
 func sanitizeName(name string) string {
@@ -416,27 +2287,92 @@ func sanitizeName(name string) string {
 	return strings.TrimSpace(b.String())
 }
 
-func getCurrentProxy(client *http.Client, cfg Config) (string, bool) {
+func getGroupInfo(ctx context.Context, client *http.Client, cfg Config) (GroupInfo, error) {
 	endpoint := fmt.Sprintf("%s/proxies/%s", cfg.ControllerURL, url.PathEscape(cfg.ProxyGroup))
-	payload, err := controllerRequest(client, cfg, http.MethodGet, endpoint, nil)
+	payload, err := controllerRequest(ctx, client, cfg, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return GroupInfo{}, err
+	}
+	info := GroupInfo{All: []string{}}
+	info.Now, _ = payload["now"].(string)
+	info.Type, _ = payload["type"].(string)
+	if all, ok := payload["all"].([]any); ok {
+		for _, item := range all {
+			if name, ok := item.(string); ok {
+				info.All = append(info.All, name)
+			}
+		}
+	}
+	return info, nil
+}
+
+// validateProxyGroup checks at startup that every MIHOMO_PROXY_GROUP entry
+// names a proxy group, listing the available groups when one does not. An
+// unreachable controller is not an error here; the cycles report it.
+func validateProxyGroup(client *http.Client, cfg Config) error {
+	payload, err := controllerRequest(context.Background(), client, cfg, http.MethodGet, cfg.ControllerURL+"/proxies", nil)
+	if err != nil {
+		logWarnf("Proxy group validation skipped: %v", err)
+		return nil
+	}
+	proxies, _ := payload["proxies"].(map[string]any)
+	groups := make([]string, 0)
+	for name, raw := range proxies {
+		if item, ok := raw.(map[string]any); ok {
+			if _, isGroup := item["all"].([]any); isGroup {
+				groups = append(groups, name)
+			}
+		}
+	}
+	sort.Strings(groups)
+	for _, group := range proxyGroups(cfg) {
+		if i := sort.SearchStrings(groups, group); i < len(groups) && groups[i] == group {
+			continue
+		}
+		problem := "does not exist"
+		if _, exists := proxies[group]; exists {
+			problem = "is a proxy, not a selectable group"
+		}
+		return fmt.Errorf("MIHOMO_PROXY_GROUP %q %s; available groups: %s (set SKIP_GROUP_VALIDATION=true to skip this check)",
+			group, problem, strings.Join(groups, ", "))
+	}
+	return nil
+}
+
+// isAutoManagedGroup reports whether the controller picks a group's "now"
+// itself, so a manual selection may be overridden on its next check.
+func isAutoManagedGroup(groupType string) bool {
+	switch strings.ToLower(groupType) {
+	case "urltest", "fallback", "loadbalance":
+		return true
+	}
+	return false
+}
+
+func getCurrentProxy(client *http.Client, cfg Config) (string, bool) {
+	info, err := getGroupInfo(context.Background(), client, cfg)
 	if err != nil {
-		log.Printf("Current proxy check failed: %v", err)
+		logWarnf("Current proxy check failed: %v", err)
 		return "", false
 	}
-	now, ok := payload["now"].(string)
-	if !ok || now == "" {
+	if info.Now == "" {
 		return "", false
 	}
-	return now, true
+	return info.Now, true
 }
 
-func switchProxy(client *http.Client, cfg Config, candidate ProxyDelay) error {
+func switchProxy(ctx context.Context, client *http.Client, cfg Config, candidate ProxyDelay) error {
 	endpoint := fmt.Sprintf("%s/proxies/%s", cfg.ControllerURL, url.PathEscape(cfg.ProxyGroup))
 	body, err := json.Marshal(map[string]string{"name": candidate.Name})
 	if err != nil {
 		return err
 	}
-	_, err = controllerRequest(client, cfg, http.MethodPut, endpoint, body)
+	_, err = controllerRequest(ctx, client, cfg, http.MethodPut, endpoint, body)
+	return err
+}
+
+func closeConnections(ctx context.Context, client *http.Client, cfg Config) error {
+	_, err := controllerRequest(ctx, client, cfg, http.MethodDelete, cfg.ControllerURL+"/connections", nil)
 	return err
 }
 
@@ -461,13 +2397,17 @@ func buildTransportForProxy(proxyAddr string) (*http.Transport, error) {
 		transport.Proxy = http.ProxyURL(proxyURL)
 		return transport, nil
 	case "socks5", "socks5h":
-		dialer, err := proxy.FromURL(proxyURL, proxy.Direct)
+		dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, socksAuth(proxyURL), proxy.Direct)
 		if err != nil {
 			return nil, err
 		}
 		transport.Proxy = nil
-		transport.DialContext = func(_ context.Context, network, addr string) (net.Conn, error) {
-			return dialer.Dial(network, addr)
+		if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+			transport.DialContext = contextDialer.DialContext
+		} else {
+			transport.DialContext = func(_ context.Context, network, addr string) (net.Conn, error) {
+				return dialer.Dial(network, addr)
+			}
 		}
 		return transport, nil
 	default:
@@ -475,6 +2415,43 @@ func buildTransportForProxy(proxyAddr string) (*http.Transport, error) {
 	}
 }
 
+// errFamilyThroughProxy is returned for ENDPOINT_NETWORK tcp4/tcp6 probes
+// through MIHOMO_PROXY_ADDR: both HTTP and SOCKS5 proxies resolve the endpoint
+// host themselves, so the address family cannot be forced from here.
+var errFamilyThroughProxy = errors.New("address family cannot be forced through a proxy")
+
+// buildEndpointTransport is the transport for endpoint probes. ENDPOINT_NETWORK
+// tcp4/tcp6 pins the dialer's network for direct probes only.
+func buildEndpointTransport(cfg Config) (*http.Transport, error) {
+	network := cfg.EndpointNetwork
+	if network != "tcp4" && network != "tcp6" {
+		return buildTransportForProxy(cfg.ProxyAddr)
+	}
+	if strings.TrimSpace(cfg.ProxyAddr) != "" {
+		return nil, errFamilyThroughProxy
+	}
+	transport, err := buildBaseTransportNoEnvProxy()
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return transport, nil
+}
+
+// socksAuth returns the username/password credentials from a SOCKS5 proxy
+// URL's userinfo, or nil when it has none. HTTP proxies need no such step:
+// the transport sends userinfo as Proxy-Authorization by itself.
+func socksAuth(proxyURL *url.URL) *proxy.Auth {
+	if proxyURL.User == nil || proxyURL.User.Username() == "" {
+		return nil
+	}
+	password, _ := proxyURL.User.Password()
+	return &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+}
+
 func buildBaseTransportNoEnvProxy() (*http.Transport, error) {
 	base, ok := http.DefaultTransport.(*http.Transport)
 	if !ok {
@@ -485,13 +2462,69 @@ func buildBaseTransportNoEnvProxy() (*http.Transport, error) {
 	return transport, nil
 }
 
-func checkEndpoint(proxyAddr, targetURL string, timeout time.Duration) EndpointResult {
-	transport, err := buildTransportForProxy(proxyAddr)
+// buildControllerTransport is the transport for controller requests only:
+// CONTROLLER_CA_FILE replaces the system roots and
+// CONTROLLER_INSECURE_SKIP_VERIFY disables verification altogether.
+func buildControllerTransport(cfg Config) (*http.Transport, error) {
+	transport, err := buildBaseTransportNoEnvProxy()
 	if err != nil {
-		return EndpointResult{URL: targetURL, Reachable: false, LatencyMS: -1}
+		return nil, err
+	}
+	if cfg.ControllerCAFile == "" && !cfg.ControllerInsecure {
+		return transport, nil
+	}
+	tlsConfig := &tls.Config{}
+	if transport.TLSClientConfig != nil {
+		tlsConfig = transport.TLSClientConfig.Clone()
+	}
+	if cfg.ControllerCAFile != "" {
+		pemData, err := os.ReadFile(cfg.ControllerCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("CONTROLLER_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("CONTROLLER_CA_FILE: no PEM certificates found in %s", cfg.ControllerCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ControllerInsecure {
+		logWarnf("Warning: CONTROLLER_INSECURE_SKIP_VERIFY is set; the controller's TLS certificate is not verified")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func checkEndpoint(ctx context.Context, cfg Config, targetURL string, timeout time.Duration) EndpointResult {
+	result := probeEndpoint(ctx, cfg, targetURL, timeout)
+	for attempt := 0; attempt < cfg.EndpointRetry && !result.Reachable && !result.Unsupported && ctx.Err() == nil; attempt++ {
+		time.Sleep(endpointRetryDelay)
+		result = probeEndpoint(ctx, cfg, targetURL, timeout)
+	}
+	return result
+}
+
+func probeEndpoint(ctx context.Context, cfg Config, targetURL string, timeout time.Duration) EndpointResult {
+	requestURL, speedtest := resolveEndpointURL(targetURL, cfg)
+	transport, err := buildEndpointTransport(cfg)
+	if err != nil {
+		return EndpointResult{URL: targetURL, Reachable: false, LatencyMS: -1, Unsupported: errors.Is(err, errFamilyThroughProxy)}
 	}
 	client := &http.Client{Transport: transport, Timeout: timeout}
-	req, err := http.NewRequest(http.MethodHead, targetURL, nil)
+	if cfg.NoFollowRedirects {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	method := cfg.EndpointMethod
+	if method == "" {
+		method = http.MethodHead
+	}
+	if cfg.ExpectBody != "" || speedtest {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, nil)
 	if err != nil {
 		return EndpointResult{URL: targetURL, Reachable: false, LatencyMS: -1}
 	}
@@ -502,103 +2535,440 @@ func checkEndpoint(proxyAddr, targetURL string, timeout time.Duration) EndpointR
 		return EndpointResult{URL: targetURL, Reachable: false, LatencyMS: -1}
 	}
 	defer resp.Body.Close()
+	headerLatencyMS := int(time.Since(start).Milliseconds())
 
+	reachable := endpointStatusOK(resp.StatusCode, cfg)
+	var throughput *float64
+	bodyBytes := resp.ContentLength
+	if reachable && speedtest {
+		throughput = measureThroughput(resp.Body, cfg.SpeedtestBytes)
+	} else if reachable && cfg.ExpectBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, expectBodyReadLimit))
+		reachable = err == nil && strings.Contains(string(body), cfg.ExpectBody)
+		bodyBytes = int64(len(body))
+	}
 	latencyMS := int(time.Since(start).Milliseconds())
-	return EndpointResult{URL: targetURL, Reachable: resp.StatusCode < 500, LatencyMS: latencyMS}
+	if speedtest {
+		latencyMS = headerLatencyMS
+	}
+	result := EndpointResult{URL: targetURL, Reachable: reachable, LatencyMS: latencyMS, StatusCode: resp.StatusCode, ThroughputMbps: throughput}
+	if resp.Request != nil && resp.Request.URL != nil {
+		result.FinalURL = redactURLPassword(resp.Request.URL.String())
+		result.Redirected = resp.Request.URL.String() != req.URL.String()
+	}
+	if bodyBytes >= 0 {
+		result.BodyBytes = &bodyBytes
+	}
+	if cfg.CheckTLSExpiry {
+		result.CertExpires, result.DaysRemaining = tlsExpiry(resp.TLS, time.Now())
+	}
+	return result
+}
+
+// statusRange is an inclusive range of HTTP status codes.
+type statusRange struct {
+	Low, High int
+}
+
+// parseStatusSpec parses an ENDPOINT_OK_STATUS value such as "200-399,401".
+func parseStatusSpec(raw string) ([]statusRange, error) {
+	ranges := make([]statusRange, 0)
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		lowText, highText, isRange := strings.Cut(item, "-")
+		low, err := strconv.Atoi(strings.TrimSpace(lowText))
+		high := low
+		if err == nil && isRange {
+			high, err = strconv.Atoi(strings.TrimSpace(highText))
+		}
+		if err != nil || low < 100 || high > 599 || low > high {
+			return nil, fmt.Errorf("invalid status code or range %q", item)
+		}
+		ranges = append(ranges, statusRange{Low: low, High: high})
+	}
+	return ranges, nil
+}
+
+// endpointStatusOK reports whether an endpoint answering code counts as
+// reachable: any status in ENDPOINT_OK_STATUS, or below 500 when it is unset.
+func endpointStatusOK(code int, cfg Config) bool {
+	if len(cfg.EndpointOKStatus) == 0 {
+		return code < 500
+	}
+	for _, r := range cfg.EndpointOKStatus {
+		if code >= r.Low && code <= r.High {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveEndpointURL turns an ENDPOINT_URLS entry into the URL to request:
+// the speedtest tag is stripped and ENDPOINT_HEALTH_PATH is applied.
+func resolveEndpointURL(raw string, cfg Config) (string, bool) {
+	requestURL, speedtest := splitEndpointTag(raw)
+	return withHealthPath(requestURL, cfg.HealthPath), speedtest
+}
+
+// withHealthPath appends path to an endpoint given without a path (or just
+// "/"); endpoints that specify a path or query are left untouched.
+func withHealthPath(raw, path string) string {
+	if path == "" {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return raw
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return raw
+	}
+	u.Path = "/" + strings.TrimPrefix(ref.Path, "/")
+	u.RawQuery = ref.RawQuery
+	return u.String()
+}
+
+// splitEndpointTag strips the "speedtest+" prefix that marks an endpoint for
+// throughput measurement.
+func splitEndpointTag(raw string) (string, bool) {
+	if rest, ok := strings.CutPrefix(raw, speedtestTag); ok {
+		return rest, true
+	}
+	return raw, false
+}
+
+// measureThroughput reads up to limit bytes of body and returns the download
+// rate in Mbps, or nil when nothing could be read.
+func measureThroughput(body io.Reader, limit int64) *float64 {
+	start := time.Now()
+	n, _ := io.Copy(io.Discard, io.LimitReader(body, limit))
+	elapsed := time.Since(start).Seconds()
+	if n == 0 {
+		return nil
+	}
+	if elapsed <= 0 {
+		elapsed = 1e-6
+	}
+	mbps := math.Round(float64(n)*8/elapsed/1e6*100) / 100
+	return &mbps
+}
+
+// isSwitchStatus reports whether an endpoint answered with one of
+// ENDPOINT_SWITCH_STATUSES, which counts as a failure for switching even
+// though the endpoint was reachable.
+func isSwitchStatus(item EndpointResult, cfg Config) bool {
+	for _, code := range cfg.SwitchStatuses {
+		if item.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAllEndpointsDualStack runs checkAllEndpoints and then repeats every
+// probe over IPv4 and IPv6 only, attaching the results per family.
+func checkAllEndpointsDualStack(ctx context.Context, cfg Config, urls []string) []EndpointResult {
+	results := checkAllEndpoints(ctx, cfg, urls)
+	cfg.EndpointNetwork = "tcp4"
+	ipv4 := checkAllEndpoints(ctx, cfg, urls)
+	cfg.EndpointNetwork = "tcp6"
+	ipv6 := checkAllEndpoints(ctx, cfg, urls)
+	for i := range results {
+		results[i].IPv4 = &ipv4[i]
+		results[i].IPv6 = &ipv6[i]
+	}
+	return results
+}
+
+// tlsExpiry reports the leaf certificate's NotAfter and whole days left, or
+// zero values for plain HTTP responses.
+func tlsExpiry(state *tls.ConnectionState, now time.Time) (string, *int) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return "", nil
+	}
+	notAfter := state.PeerCertificates[0].NotAfter
+	days := int(notAfter.Sub(now).Hours() / 24)
+	return notAfter.UTC().Format(time.RFC3339), &days
 }
 
-func checkAllEndpoints(proxyAddr string, urls []string) []EndpointResult {
-	if len(urls) == 0 || strings.TrimSpace(proxyAddr) == "" {
+func checkAllEndpoints(ctx context.Context, cfg Config, urls []string) []EndpointResult {
+	if len(urls) == 0 || strings.TrimSpace(cfg.ProxyAddr) == "" {
 		return []EndpointResult{}
 	}
+	return checkEndpointsConcurrently(ctx, cfg, urls)
+}
+
+// checkDirectEndpoints probes urls without any proxy.
+func checkDirectEndpoints(ctx context.Context, cfg Config, urls []string) []EndpointResult {
+	cfg.ProxyAddr = ""
+	return checkEndpointsConcurrently(ctx, cfg, urls)
+}
+
+// attachDirectBaseline records each endpoint's direct latency on the proxied
+// result, and the proxy overhead when both paths reached it.
+func attachDirectBaseline(results, direct []EndpointResult) {
+	for i := range results {
+		if i >= len(direct) {
+			return
+		}
+		directMS := -1
+		if direct[i].Reachable {
+			directMS = direct[i].LatencyMS
+		}
+		results[i].DirectLatencyMS = &directMS
+		if results[i].Reachable && direct[i].Reachable {
+			overhead := results[i].LatencyMS - directMS
+			results[i].OverheadMS = &overhead
+		}
+	}
+}
+
+func checkEndpointsConcurrently(ctx context.Context, cfg Config, urls []string) []EndpointResult {
 	results := make([]EndpointResult, len(urls))
 	var wg sync.WaitGroup
 	for idx, endpoint := range urls {
 		wg.Add(1)
 		go func(i int, target string) {
 			defer wg.Done()
-			results[i] = checkEndpoint(proxyAddr, target, 10*time.Second)
+			results[i] = checkEndpoint(ctx, cfg, target, 10*time.Second)
 		}(idx, endpoint)
 	}
 	wg.Wait()
 	return results
 }
 
+// jsonSchemaVersion is reported as schema_version on every JSON output object.
+// It is bumped when a field is removed, renamed, or changes meaning or type;
+// adding fields does not bump it. Version 2: --print-delays --json prints the
+// no-delay-data object instead of an empty array.
+const jsonSchemaVersion = 2
+
+// printJSON stamps obj with the schema version and prints it as one line.
+func printJSON(obj map[string]any) {
+	obj["schema_version"] = jsonSchemaVersion
+	fmt.Println(mustASCIIJSON(obj))
+}
+
 func mustASCIIJSON(v any) string {
 	raw, err := json.Marshal(v)
 	if err != nil {
 		return "{}"
 	}
-	return escapeNonASCII(raw)
+	return escapeNonASCII(raw)
+}
+
+func escapeNonASCII(raw []byte) string {
+	buf := make([]byte, 0, len(raw)+16)
+	for i := 0; i < len(raw); {
+		if raw[i] < utf8.RuneSelf {
+			buf = append(buf, raw[i])
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(raw[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, raw[i])
+			i++
+			continue
+		}
+		buf = appendEscapedRune(buf, r)
+		i += size
+	}
+	return string(buf)
+}
+
+func appendEscapedRune(dst []byte, r rune) []byte {
+	if r <= 0xFFFF {
+		return append(dst, []byte(fmt.Sprintf("\\u%04x", r))...)
+	}
+	for _, part := range utf16.Encode([]rune{r}) {
+		dst = append(dst, []byte(fmt.Sprintf("\\u%04x", part))...)
+	}
+	return dst
+}
+
+func formatDelay(delayMS int, cfg Config) string {
+	if cfg.OutputDelayUnit == "s" {
+		return strconv.FormatFloat(float64(delayMS)/1000, 'f', -1, 64) + "s"
+	}
+	return fmt.Sprintf("%dms", delayMS)
+}
+
+// addDelayHuman adds a formatted copy of delayMS when a non-ms unit is
+// configured; delay_ms itself always stays in milliseconds.
+func addDelayHuman(item map[string]any, delayMS int, cfg Config) map[string]any {
+	if cfg.OutputDelayUnit == "s" {
+		item["delay_human"] = formatDelay(delayMS, cfg)
+	}
+	return item
+}
+
+func printDelaysOnce(client *http.Client, cfg Config, jsonOutput bool) {
+	delays, reason := topGroupDelays(client, cfg)
+	if jsonOutput {
+		fmt.Println(mustASCIIJSON(delaysPayload(cfg, delays, reason)))
+		return
+	}
+
+	fmt.Printf("test_url\t%s\n", cfg.TestURL)
+	if len(delays) == 0 {
+		fmt.Println(noDataText(reason))
+		return
+	}
+	for _, item := range delays {
+		fmt.Printf("%s\t%s\n", formatDelay(item.DelayMS, cfg), sanitizeName(item.Name))
+	}
+}
+
+// topGroupDelays returns the ten fastest group delays for --print-delays.
+func topGroupDelays(client *http.Client, cfg Config) ([]ProxyDelay, string) {
+	refreshGroupHealth(context.Background(), client, cfg, "")
+	delays, reason := getGroupDelaysWithReason(client, cfg, cfg.FilterHKNodes)
+	sortDelays(delays)
+	if len(delays) > 10 {
+		delays = delays[:10]
+	}
+	return delays, reason
+}
+
+// delaysPayload is the --print-delays --json output: the delay list, or the
+// no-data object when it is empty.
+func delaysPayload(cfg Config, delays []ProxyDelay, reason string) any {
+	if len(delays) == 0 {
+		result := noDataResult(reason)
+		result["schema_version"] = jsonSchemaVersion
+		return result
+	}
+	payload := make([]map[string]any, 0, len(delays))
+	for _, item := range delays {
+		entry := map[string]any{"name": item.Name, "delay_ms": item.DelayMS, "test_url": cfg.TestURL, "schema_version": jsonSchemaVersion}
+		payload = append(payload, addDelayHuman(entry, item.DelayMS, cfg))
+	}
+	return payload
+}
+
+// delayRank is name's 1-based position in sorted delays, or 0 if absent.
+func delayRank(delays []ProxyDelay, name string) int {
+	for i, item := range delays {
+		if item.Name == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func sortDelays(delays []ProxyDelay) {
+	for i := 1; i < len(delays); i++ {
+		j := i
+		for j > 0 && delays[j-1].DelayMS > delays[j].DelayMS {
+			delays[j-1], delays[j] = delays[j], delays[j-1]
+			j--
+		}
+	}
+}
+
+func parseBucketBounds(raw string) ([]int, error) {
+	bounds := make([]int, 0)
+	for _, item := range strings.Split(raw, ",") {
+		trimmed := strings.TrimSpace(item)
+		if trimmed == "" {
+			continue
+		}
+		bound, err := strconv.Atoi(trimmed)
+		if err != nil || bound <= 0 {
+			return nil, fmt.Errorf("invalid bucket boundary %q", trimmed)
+		}
+		if len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			return nil, errors.New("bucket boundaries must be strictly ascending")
+		}
+		bounds = append(bounds, bound)
+	}
+	if len(bounds) == 0 {
+		return nil, errors.New("at least one bucket boundary is required")
+	}
+	return bounds, nil
 }
 
-func escapeNonASCII(raw []byte) string {
-	buf := make([]byte, 0, len(raw)+16)
-	for i := 0; i < len(raw); {
-		if raw[i] < utf8.RuneSelf {
-			buf = append(buf, raw[i])
-			i++
-			continue
+// buildDelayHistogram counts delays into [0,b0), [b0,b1), ..., [bN,inf)
+// buckets followed by a timeout bucket. Open bounds are reported as -1.
+func buildDelayHistogram(delays []ProxyDelay, timeouts int, bounds []int) []DelayBucket {
+	buckets := make([]DelayBucket, 0, len(bounds)+2)
+	lower := 0
+	for _, bound := range bounds {
+		label := fmt.Sprintf("%d-%dms", lower, bound)
+		if lower == 0 {
+			label = fmt.Sprintf("<%dms", bound)
 		}
-		r, size := utf8.DecodeRune(raw[i:])
-		if r == utf8.RuneError && size == 1 {
-			buf = append(buf, raw[i])
-			i++
-			continue
+		buckets = append(buckets, DelayBucket{Label: label, MinMS: lower, MaxMS: bound})
+		lower = bound
+	}
+	buckets = append(buckets, DelayBucket{Label: fmt.Sprintf(">=%dms", lower), MinMS: lower, MaxMS: -1})
+
+	for _, item := range delays {
+		for i := range buckets {
+			if item.DelayMS >= buckets[i].MinMS && (buckets[i].MaxMS < 0 || item.DelayMS < buckets[i].MaxMS) {
+				buckets[i].Count++
+				break
+			}
 		}
-		buf = appendEscapedRune(buf, r)
-		i += size
 	}
-	return string(buf)
+	return append(buckets, DelayBucket{Label: "timeout", MinMS: -1, MaxMS: -1, Count: timeouts})
 }
 
-func appendEscapedRune(dst []byte, r rune) []byte {
-	if r <= 0xFFFF {
-		return append(dst, []byte(fmt.Sprintf("\\u%04x", r))...)
+func countGroupTimeouts(client *http.Client, cfg Config, delays []ProxyDelay) int {
+	info, err := getGroupInfo(context.Background(), client, cfg)
+	if err != nil {
+		logWarnf("Group member lookup failed, timeout bucket unavailable: %v", err)
+		return 0
 	}
-	for _, part := range utf16.Encode([]rune{r}) {
-		dst = append(dst, []byte(fmt.Sprintf("\\u%04x", part))...)
+	measured := make(map[string]bool, len(delays))
+	for _, item := range delays {
+		measured[item.Name] = true
 	}
-	return dst
+	timeouts := 0
+	for _, name := range info.All {
+		if isFilteredProxy(name, cfg) {
+			continue
+		}
+		if !measured[name] {
+			timeouts++
+		}
+	}
+	return timeouts
 }
 
-func printDelaysOnce(client *http.Client, cfg Config, jsonOutput bool) {
+func printDelayHistogramOnce(client *http.Client, cfg Config, bounds []int, jsonOutput bool, format string) {
 	delays := getGroupDelays(client, cfg)
-	sortDelays(delays)
-	if len(delays) > 10 {
-		delays = delays[:10]
-	}
+	buckets := buildDelayHistogram(delays, countGroupTimeouts(client, cfg, delays), bounds)
 
-	if len(delays) == 0 {
-		if jsonOutput {
-			fmt.Println("[]")
-		} else {
-			fmt.Println("No delay data returned")
-		}
+	if format == "grafana" {
+		printGrafanaRows(histogramGrafanaRows(buckets, time.Now()))
 		return
 	}
 
 	if jsonOutput {
-		payload := make([]map[string]any, 0, len(delays))
-		for _, item := range delays {
-			payload = append(payload, map[string]any{"name": item.Name, "delay_ms": item.DelayMS})
+		payload := make([]map[string]any, 0, len(buckets))
+		for _, bucket := range buckets {
+			item := map[string]any{"label": bucket.Label, "min_ms": nil, "max_ms": nil, "count": bucket.Count}
+			if bucket.MinMS >= 0 {
+				item["min_ms"] = bucket.MinMS
+			}
+			if bucket.MaxMS >= 0 {
+				item["max_ms"] = bucket.MaxMS
+			}
+			payload = append(payload, item)
 		}
-		fmt.Println(mustASCIIJSON(payload))
+		printJSON(map[string]any{"test_url": cfg.TestURL, "buckets": payload})
 		return
 	}
 
-	for _, item := range delays {
-		fmt.Printf("%dms\t%s\n", item.DelayMS, sanitizeName(item.Name))
-	}
-}
-
-func sortDelays(delays []ProxyDelay) {
-	for i := 1; i < len(delays); i++ {
-		j := i
-		for j > 0 && delays[j-1].DelayMS > delays[j].DelayMS {
-			delays[j-1], delays[j] = delays[j], delays[j-1]
-			j--
-		}
+	fmt.Printf("test_url\t%s\n", cfg.TestURL)
+	for _, bucket := range buckets {
+		fmt.Printf("%s\t%d\n", bucket.Label, bucket.Count)
 	}
 }
 
@@ -606,14 +2976,14 @@ func printCurrentDelayOnce(client *http.Client, cfg Config, jsonOutput bool) {
 	current, ok := getCurrentProxy(client, cfg)
 	if !ok {
 		if jsonOutput {
-			fmt.Println(mustASCIIJSON(map[string]any{"error": "current proxy not found"}))
+			printJSON(map[string]any{"error": "current proxy not found"})
 		} else {
 			fmt.Println("Current proxy not found")
 		}
 		return
 	}
 
-	delays := getGroupDelaysWithFilter(client, cfg, false)
+	delays := getAllGroupDelays(client, cfg)
 	delayMap := make(map[string]int, len(delays))
 	for _, item := range delays {
 		delayMap[item.Name] = item.DelayMS
@@ -622,7 +2992,7 @@ func printCurrentDelayOnce(client *http.Client, cfg Config, jsonOutput bool) {
 	delayMS, exists := delayMap[current]
 	if !exists {
 		if jsonOutput {
-			fmt.Println(mustASCIIJSON(map[string]any{"name": current, "delay_ms": nil}))
+			printJSON(map[string]any{"name": current, "delay_ms": nil})
 		} else {
 			fmt.Printf("delay unavailable\t%s\n", sanitizeName(current))
 		}
@@ -630,35 +3000,139 @@ func printCurrentDelayOnce(client *http.Client, cfg Config, jsonOutput bool) {
 	}
 
 	if jsonOutput {
-		fmt.Println(mustASCIIJSON(map[string]any{"name": current, "delay_ms": delayMS}))
+		printJSON(addDelayHuman(map[string]any{"name": current, "delay_ms": delayMS}, delayMS, cfg))
 		return
 	}
-	fmt.Printf("%dms\t%s\n", delayMS, sanitizeName(current))
+	fmt.Printf("%s\t%s\n", formatDelay(delayMS, cfg), sanitizeName(current))
 }
 
-func autoSelectOnce(client *http.Client, cfg Config, jsonOutput, dryRun bool) {
-	current, currentFound := getCurrentProxy(client, cfg)
-	delays := getGroupDelays(client, cfg)
-	sortDelays(delays)
-	if len(delays) == 0 && cfg.FilterHKNodes {
-		delays = getGroupDelaysWithFilter(client, cfg, false)
-		sortDelays(delays)
-		if len(delays) > 0 {
-			log.Printf("FILTER_HK_NODES removed all delay candidates; fallback to unfiltered delays")
+func emitResult(ctx context.Context, cfg Config, result map[string]any, jsonOutput bool) map[string]any {
+	// Requests cut short by RUN_TIMEOUT_MS make any decision unreliable, so the
+	// tick is skipped instead. A switch that was attempted keeps its outcome,
+	// switched or switch_failed, so a request cut off in flight is not hidden.
+	if cfg.RunTimeoutMS > 0 && ctx.Err() != nil && !switchAttempted(result) && result["action"] != "skipped" {
+		reason := fmt.Sprintf("run exceeded RUN_TIMEOUT_MS (%dms)", cfg.RunTimeoutMS)
+		logWarnf("Auto-select %s; skipping this tick", reason)
+		result = map[string]any{"action": "skipped", "reason": reason}
+	}
+	if cfg.ReportControllerLoad && cfg.controller != nil {
+		result["controller_requests"], result["controller_time_ms"] = cfg.controller.cycleLoad()
+	}
+	if cfg.collectOnly {
+		return result
+	}
+	if jsonOutput {
+		printJSON(result)
+		return result
+	}
+	if cfg.OutputTemplate != nil {
+		rendered, err := renderOutputTemplate(cfg.OutputTemplate, result)
+		if err == nil {
+			fmt.Println(rendered)
+			return result
 		}
+		logWarnf("OUTPUT_TEMPLATE failed, using default output: %v", err)
+	}
+	rendered, err := renderOutputTemplate(defaultTemplate(cfg.OutputDelayUnit), result)
+	if err != nil {
+		logErrorf("Default output failed: %v", err)
+		return result
 	}
+	fmt.Println(rendered)
+	return result
+}
 
-	if len(delays) == 0 {
-		if jsonOutput {
-			fmt.Println(mustASCIIJSON(map[string]any{"error": "no delay data"}))
-		} else {
-			fmt.Println("No delay data returned")
+func switchAttempted(result map[string]any) bool {
+	return result["action"] == "switched" || result["action"] == "switch_failed"
+}
+
+func emitSkipped(cfg Config, reason string, jsonOutput bool) map[string]any {
+	return emitResult(context.Background(), cfg, map[string]any{"action": "skipped", "reason": reason}, jsonOutput)
+}
+
+func autoSelectOnce(ctx context.Context, client *http.Client, cfg Config, jsonOutput, dryRun bool) (result map[string]any) {
+	defer func() { recordHistory(cfg, result) }()
+	if cfg.LockFile != "" {
+		lock, err := acquireFileLock(cfg.LockFile)
+		if errors.Is(err, errLockHeld) {
+			return emitSkipped(cfg, errLockHeld.Error(), jsonOutput)
+		}
+		if err != nil {
+			logWarnf("Lock file unavailable, continuing without lock: %v", err)
+		}
+		defer releaseFileLock(lock)
+	}
+	if paused(cfg) {
+		return emitSkipped(cfg, "paused", jsonOutput)
+	}
+	if cfg.RunTimeoutMS > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.RunTimeoutMS)*time.Millisecond)
+		defer cancel()
+	}
+
+	cfg, activeWindow := applySchedule(cfg, time.Now())
+	info, err := getGroupInfo(ctx, client, cfg)
+	if err != nil {
+		logWarnf("Current proxy check failed: %v", err)
+	}
+	current, currentFound := info.Now, info.Now != ""
+	autoManaged := isAutoManagedGroup(info.Type)
+
+	endpointResults := []EndpointResult{}
+	allEndpointsOK := true
+	allEndpointsDown := false
+	if len(cfg.EndpointURLs) > 0 && strings.TrimSpace(cfg.ProxyAddr) != "" {
+		endpointResults = checkAllEndpoints(ctx, cfg, cfg.EndpointURLs)
+		passed := 0
+		for _, item := range endpointResults {
+			if item.Reachable && !isSwitchStatus(item, cfg) {
+				passed++
+			}
+		}
+		allEndpointsDown = passed == 0
+		allEndpointsOK = passed >= cfg.EndpointQuorum.required(len(endpointResults))
+	}
+
+	probed := make(map[string]bool)
+	sticky := currentFound && matchesAny(current, cfg.StickyProxies)
+	var delays, allDelays []ProxyDelay
+	priorityProbes := 0
+	if len(cfg.ProbePriority) > 0 && currentFound && allEndpointsOK && !sticky {
+		delays, priorityProbes = probePriority(ctx, client, cfg, current, info.All, probed)
+		allDelays = delays
+		if delays == nil {
+			logInfof("No PROBE_PRIORITY node met the keep threshold after %d probes; probing the whole group", priorityProbes)
+		}
+	}
+	if delays == nil {
+		refreshGroupHealth(ctx, client, cfg, info.Type)
+		read := readGroupDelays(ctx, client, cfg)
+		var reason string
+		delays, reason = read.delays(cfg, cfg.FilterHKNodes)
+		if len(delays) == 0 && cfg.FilterHKNodes && reason != noDataControllerUnreachable && reason != noDataControllerError {
+			delays, reason = read.delays(cfg, false)
+			if len(delays) > 0 {
+				logWarnf("FILTER_HK_NODES removed all delay candidates; fallback to unfiltered delays")
+			}
+		}
+
+		delays = withoutBuiltinProxies(delays)
+		if len(delays) == 0 {
+			return emitResult(ctx, cfg, noDataResult(reason), jsonOutput)
+		}
+		allDelays, _ = read.all(cfg)
+		if cfg.reliability != nil && len(allDelays) > 0 {
+			cfg.reliability.record(allDelays)
+		}
+		if cfg.ewma != nil && len(allDelays) > 0 {
+			cfg.ewma.update(allDelays, cfg.DelayEWMAAlpha)
+			allDelays = cfg.ewma.smooth(allDelays)
+			delays = cfg.ewma.smooth(delays)
 		}
-		return
 	}
 
 	best := delays[0]
-	allDelays := getGroupDelaysWithFilter(client, cfg, false)
 	delayMap := make(map[string]int, len(allDelays))
 	for _, item := range allDelays {
 		delayMap[item.Name] = item.DelayMS
@@ -670,47 +3144,92 @@ func autoSelectOnce(client *http.Client, cfg Config, jsonOutput, dryRun bool) {
 			currentDelay = &d
 		}
 	}
-
-	endpointResults := []EndpointResult{}
-	allEndpointsOK := true
-	if len(cfg.EndpointURLs) > 0 && strings.TrimSpace(cfg.ProxyAddr) != "" {
-		endpointResults = checkAllEndpoints(cfg.ProxyAddr, cfg.EndpointURLs)
-		for _, item := range endpointResults {
-			if !item.Reachable {
-				allEndpointsOK = false
-				break
-			}
-		}
+	effect := switchEffectiveness(cfg.lastSwitch, current, currentDelay)
+	if effect != nil {
+		logInfof("Last switch %s", formatEffectiveness(effect))
 	}
 
 	shouldSwitch := false
 	reason := ""
+	switchType := "performance"
+	candidates := orderCandidates(delays, current, cfg)
+	if cfg.ScoreExpr != nil {
+		candidates = scoreCandidates(ctx, client, cfg, delays, current)
+	}
 
 	if !currentFound {
 		shouldSwitch = false
 		reason = "current proxy not found"
+	} else if isBuiltinProxy(current) && !cfg.ForceOffDirect {
+		shouldSwitch = false
+		reason = fmt.Sprintf("current proxy is built-in %s, not a real node; set FORCE_OFF_DIRECT=true to switch away", current)
+	} else if isBuiltinProxy(current) {
+		switchType = "emergency"
+		target, found := findBestAlternative(candidates, current)
+		label := "fastest real node"
+		if found && len(cfg.EndpointURLs) > 0 {
+			target, found = probeReachableAlternative(ctx, client, cfg, candidates, current, cfg.EndpointURLs, probed)
+			label = "fastest endpoint-verified real node"
+		}
+		if found {
+			shouldSwitch = true
+			best = target
+			reason = fmt.Sprintf("current proxy is built-in %s; FORCE_OFF_DIRECT switch to %s", current, label)
+		} else {
+			reason = fmt.Sprintf("current proxy is built-in %s but no %s is available", current, label)
+		}
+	} else if sticky && !allEndpointsDown {
+		shouldSwitch = false
+		reason = "sticky: not switching for performance"
+		if !allEndpointsOK {
+			reason = "sticky: not switching for partial endpoint failure"
+		}
 	} else if !allEndpointsOK {
+		switchType = "emergency"
 		failed := make([]string, 0)
 		for _, item := range endpointResults {
 			if !item.Reachable {
 				failed = append(failed, item.URL)
+			} else if isSwitchStatus(item, cfg) {
+				failed = append(failed, fmt.Sprintf("%s (HTTP %d)", item.URL, item.StatusCode))
 			}
 		}
-		alt, found := findBestReachableAlternative(client, cfg, delays, current, cfg.EndpointURLs)
-		if !found {
-			alt, found = findBestAlternative(delays, current)
-			if !found {
-				shouldSwitch = false
-				reason = "endpoints unreachable but no alternative proxy available"
-			} else {
+		failedText := strings.Join(failed, ", ")
+		order := cfg.FallbackOrder
+		if len(order) == 0 {
+			order = defaultFallbackOrder
+		}
+		verifiedTried, fallbackTried := false, false
+		for _, step := range order {
+			var alt ProxyDelay
+			found := false
+			switch step {
+			case "verified":
+				verifiedTried = true
+				alt, found = probeReachableAlternative(ctx, client, cfg, candidates, current, cfg.EndpointURLs, probed)
+				reason = "endpoints unreachable: " + failedText + "; switch to endpoint-verified alternative"
+			case "fallback-proxy":
+				fallbackTried = true
+				alt, found = findBestAlternative(candidates, current)
+				reason = "endpoints unreachable: " + failedText + "; fallback to fastest alternative without endpoint verification"
+			case "keep":
+				switch {
+				case fallbackTried:
+					reason = "endpoints unreachable but no alternative proxy available"
+				case verifiedTried:
+					reason = "endpoints unreachable: " + failedText + "; no endpoint-verified alternative, keeping current"
+				default:
+					reason = "endpoints unreachable: " + failedText + "; keeping current per UNREACHABLE_FALLBACK_ORDER"
+				}
+			}
+			if found {
 				shouldSwitch = true
 				best = alt
-				reason = "endpoints unreachable: " + strings.Join(failed, ", ") + "; fallback to fastest alternative without endpoint verification"
+				break
+			}
+			if step == "keep" {
+				break
 			}
-		} else {
-			shouldSwitch = true
-			best = alt
-			reason = "endpoints unreachable: " + strings.Join(failed, ", ") + "; switch to endpoint-verified alternative"
 		}
 	} else if currentDelay == nil {
 		shouldSwitch = false
@@ -718,202 +3237,579 @@ func autoSelectOnce(client *http.Client, cfg Config, jsonOutput, dryRun bool) {
 	} else if *currentDelay <= cfg.KeepDelayThresholdMS {
 		shouldSwitch = false
 		reason = fmt.Sprintf("endpoints ok, delay %dms <= %dms threshold", *currentDelay, cfg.KeepDelayThresholdMS)
+	} else if rank := delayRank(delays, current); rank > 0 && rank <= cfg.KeepIfRankWithin {
+		shouldSwitch = false
+		reason = fmt.Sprintf("delay %dms > threshold but current ranks #%d of %d, within KEEP_IF_RANK_WITHIN=%d", *currentDelay, rank, len(delays), cfg.KeepIfRankWithin)
 	} else {
-		alt, found := findBestAlternative(delays, current)
+		target, found := findBestAlternative(candidates, current)
+		verified := false
+		if found && len(cfg.EndpointURLs) > 0 && (*currentDelay-target.DelayMS) > cfg.AutoSelectDiffMS {
+			target, found = probeReachableAlternative(ctx, client, cfg, candidates, current, cfg.EndpointURLs, probed)
+			verified = true
+		}
+		label := "best"
+		if verified {
+			label = "endpoint-verified best"
+		}
 		if !found {
 			shouldSwitch = false
-			reason = "no alternative proxy available"
-		} else if (*currentDelay - alt.DelayMS) <= cfg.AutoSelectDiffMS {
-			shouldSwitch = false
-			reason = fmt.Sprintf("delay %dms > threshold but no significantly better option", *currentDelay)
-		} else if len(cfg.EndpointURLs) == 0 {
-			shouldSwitch = true
-			best = alt
-			reason = fmt.Sprintf("delay %dms > %dms and best is %dms faster", *currentDelay, cfg.KeepDelayThresholdMS, *currentDelay-alt.DelayMS)
+			if verified {
+				reason = fmt.Sprintf("delay %dms > threshold but no endpoint-verified alternative", *currentDelay)
+			} else {
+				reason = "no alternative proxy available"
+			}
 		} else {
-			reachableAlt, reachableFound := findBestReachableAlternative(client, cfg, delays, current, cfg.EndpointURLs)
-			if !reachableFound {
+			best = target
+			diff := *currentDelay - target.DelayMS
+			if diff <= cfg.AutoSelectDiffMS {
 				shouldSwitch = false
-				reason = fmt.Sprintf("delay %dms > threshold but no endpoint-verified alternative", *currentDelay)
-			} else if (*currentDelay - reachableAlt.DelayMS) <= cfg.AutoSelectDiffMS {
+				reason = fmt.Sprintf("delay %dms > threshold but %s is only %dms faster", *currentDelay, label, diff)
+			} else if checksExitIP(cfg) && sharesExitIP(cfg, current, target.Name) {
 				shouldSwitch = false
-				reason = fmt.Sprintf("delay %dms > threshold but no sufficiently faster endpoint-verified alternative", *currentDelay)
+				reason = "candidate shares exit IP with current"
 			} else {
 				shouldSwitch = true
-				best = reachableAlt
-				reason = fmt.Sprintf("delay %dms > %dms and endpoint-verified best is %dms faster", *currentDelay, cfg.KeepDelayThresholdMS, *currentDelay-reachableAlt.DelayMS)
+				reason = fmt.Sprintf("delay %dms > %dms and %s is %dms faster", *currentDelay, cfg.KeepDelayThresholdMS, label, diff)
 			}
 		}
 	}
 
+	cooldown := 0
+	if shouldSwitch && switchType == "performance" {
+		if cooldown = cooldownRemaining(cfg, time.Now()); cooldown > 0 {
+			shouldSwitch = false
+			reason = fmt.Sprintf("%s; in cooldown, %ds remaining", reason, cooldown)
+		}
+	}
+
 	epSummary := make([]map[string]any, 0, len(endpointResults))
 	for _, item := range endpointResults {
-		epSummary = append(epSummary, map[string]any{
+		entry := map[string]any{
 			"url":        item.URL,
 			"reachable":  item.Reachable,
 			"latency_ms": item.LatencyMS,
-		})
+		}
+		if item.StatusCode != 0 {
+			entry["status_code"] = item.StatusCode
+		}
+		if item.ThroughputMbps != nil {
+			entry["throughput_mbps"] = *item.ThroughputMbps
+		}
+		epSummary = append(epSummary, entry)
 	}
 
 	if shouldSwitch && best.Name != current {
+		result := map[string]any{
+			"switch_type":   switchType,
+			"from":          current,
+			"to":            best.Name,
+			"from_delay_ms": currentDelay,
+			"to_delay_ms":   best.DelayMS,
+			"reason":        reason,
+			"endpoints":     epSummary,
+		}
+		if activeWindow != "" {
+			result["schedule"] = activeWindow
+		}
+		if priorityProbes > 0 {
+			result["priority_probes"] = priorityProbes
+		}
+		if effect != nil {
+			result["switch_effectiveness"] = effect
+		}
+		if autoManaged {
+			result["auto_managed"] = true
+			result["warning"] = fmt.Sprintf("group type %s selects its node automatically; the switch may not stick", info.Type)
+			logWarnf("Warning: %s is a %s group; the controller may override the selected node", cfg.ProxyGroup, info.Type)
+		}
 		if dryRun {
-			result := map[string]any{
-				"action":        "would_switch",
-				"dry_run":       true,
-				"from":          current,
-				"to":            best.Name,
-				"from_delay_ms": currentDelay,
-				"to_delay_ms":   best.DelayMS,
-				"reason":        reason,
-				"endpoints":     epSummary,
+			result["action"] = "would_switch"
+			result["dry_run"] = true
+			result["alternatives"] = topAlternatives(delays, current, probed, dryRunAlternativeLimit)
+			if cfg.AlertOnly {
+				result["alert"] = alertText(current, best.Name)
 			}
-			if jsonOutput {
-				fmt.Println(mustASCIIJSON(result))
-				return
+			if len(cfg.EndpointURLs) > 0 {
+				candidateResults := verifyProxyEndpoints(ctx, client, cfg, best.Name, cfg.EndpointURLs)
+				passed := 0
+				for _, item := range candidateResults {
+					if item.Reachable {
+						passed++
+					}
+				}
+				result["candidate_endpoint_results"] = candidateResults
+				result["candidate_endpoints_ok"] = passed >= cfg.EndpointQuorum.required(len(candidateResults))
 			}
-			fromName := sanitizeName(current)
-			toName := sanitizeName(best.Name)
-			currentText := "nil"
-			if currentDelay != nil {
-				currentText = fmt.Sprintf("%dms", *currentDelay)
+			return emitResult(ctx, cfg, result, jsonOutput)
+		}
+		if err := switchProxy(ctx, client, cfg, best); err != nil {
+			result["action"] = "switch_failed"
+			result["error"] = err.Error()
+			return emitResult(ctx, cfg, result, jsonOutput)
+		}
+		result["action"] = "switched"
+		cfg.delays.invalidate()
+		if cfg.CloseConnsOnSwitch {
+			err := closeConnections(ctx, client, cfg)
+			if err != nil {
+				logWarnf("Close connections after switch failed: %v", err)
 			}
-			fmt.Printf("would_switch(dry-run)\t%s\t%s -> %dms\t%s\t(%s)\n", fromName, currentText, best.DelayMS, toName, reason)
-			return
+			result["connections_closed"] = err == nil
+		}
+		if cfg.DesktopNotify {
+			notifyDesktop("Proxy switched", current, best.Name, reason)
 		}
-		if err := switchProxy(client, cfg, best); err != nil {
-			result := map[string]any{
-				"action":        "switch_failed",
-				"from":          current,
-				"to":            best.Name,
-				"from_delay_ms": currentDelay,
-				"to_delay_ms":   best.DelayMS,
-				"reason":        reason,
-				"error":         err.Error(),
-				"endpoints":     epSummary,
+		if checksExitIP(cfg) {
+			if info, err := fetchExitIP(cfg); err == nil {
+				cfg.exitIPs.store(best.Name, info.IP)
 			}
-			if jsonOutput {
-				fmt.Println(mustASCIIJSON(result))
-				return
+		}
+		return emitResult(ctx, cfg, result, jsonOutput)
+	}
+
+	result = map[string]any{
+		"action":        "kept",
+		"current":       current,
+		"delay_ms":      currentDelay,
+		"best":          best.Name,
+		"best_delay_ms": best.DelayMS,
+		"reason":        reason,
+		"endpoints":     epSummary,
+	}
+	if dryRun {
+		result["dry_run"] = true
+	}
+	if autoManaged {
+		result["auto_managed"] = true
+	}
+	if activeWindow != "" {
+		result["schedule"] = activeWindow
+	}
+	if priorityProbes > 0 {
+		result["priority_probes"] = priorityProbes
+	}
+	if effect != nil {
+		result["switch_effectiveness"] = effect
+	}
+	if cooldown > 0 {
+		result["cooldown_remaining_s"] = cooldown
+	}
+	if currentDelay != nil {
+		addDelayHuman(result, *currentDelay, cfg)
+	}
+	return emitResult(ctx, cfg, result, jsonOutput)
+}
+
+// cooldownRemaining is the number of whole seconds, rounded up, before
+// SWITCH_COOLDOWN_S allows another performance switch; 0 when it allows one
+// now.
+func cooldownRemaining(cfg Config, now time.Time) int {
+	if cfg.SwitchCooldownS <= 0 || cfg.lastSwitchAt.IsZero() {
+		return 0
+	}
+	left := cfg.lastSwitchAt.Add(time.Duration(cfg.SwitchCooldownS) * time.Second).Sub(now)
+	if left <= 0 {
+		return 0
+	}
+	return int((left + time.Second - 1) / time.Second)
+}
+
+// paused reports whether PAUSE_FILE exists; an unset PAUSE_FILE never pauses.
+func paused(cfg Config) bool {
+	if cfg.PauseFile == "" {
+		return false
+	}
+	_, err := os.Stat(cfg.PauseFile)
+	return err == nil
+}
+
+// gateOpen runs GATE_COMMAND through the shell and reports whether it exited
+// 0 within GATE_TIMEOUT_S. An unset command always opens the gate.
+func gateOpen(cfg Config) bool {
+	if cfg.GateCommand == "" {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.GateTimeoutS)*time.Second)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", cfg.GateCommand)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", cfg.GateCommand)
+	}
+	cmd.Stderr = os.Stderr
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			logWarnf("GATE_COMMAND timed out after %ds", cfg.GateTimeoutS)
+		} else {
+			logInfof("GATE_COMMAND closed the gate: %v", err)
+		}
+		return false
+	}
+	return true
+}
+
+func monitorLoop(client *http.Client, cfg Config, sink *resultSink, webhook *switchWebhook, state *monitorState, jsonOutput, dryRun bool) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+	history := newEndpointHistory(cfg.EndpointHistory)
+	recentRegions := make([]string, 0)
+	reliabilityHistory := newDelayWindow(reliabilityWindow)
+	delayAverages := newDelayEWMA()
+	var lastSwitch *switchContext
+	var lastSwitchAt time.Time
+	verdicts := make(map[string]int)
+	defer logEffectivenessSummary(verdicts)
+
+	var late time.Duration
+	for cycle := 0; ; cycle++ {
+		select {
+		case <-sigCh:
+			logInfof("Shutdown signal received")
+			return
+		default:
+		}
+
+		cfg.controller.resetCycle()
+		cfg.probeOffset = cycle
+		cfg.recentRegions = recentRegions
+		cfg.lastSwitch = lastSwitch
+		cfg.lastSwitchAt = lastSwitchAt
+		cfg.reliability = reliabilityHistory
+		cfg.ewma = nil
+		if cfg.DelayEWMAAlpha > 0 {
+			cfg.ewma = delayAverages
+		}
+		var result map[string]any
+		if resumedFromSuspend(late, cfg) {
+			logInfof("Woke %s later than scheduled, likely after suspend; skipping this cycle while the network settles", late.Round(time.Second))
+			cfg.delays.invalidate()
+			result = emitSkipped(cfg, "after suspend", jsonOutput)
+			recordHistory(cfg, result)
+		} else if gateOpen(cfg) {
+			result = autoSelectOnce(context.Background(), client, cfg, jsonOutput, dryRun || cfg.AlertOnly)
+			if cfg.AlertOnly {
+				alertRecommendation(cfg, result)
 			}
-			fromName := sanitizeName(current)
-			toName := sanitizeName(best.Name)
-			currentText := "nil"
-			if currentDelay != nil {
-				currentText = fmt.Sprintf("%dms", *currentDelay)
+		} else {
+			result = emitSkipped(cfg, "gated", jsonOutput)
+			recordHistory(cfg, result)
+		}
+		sink.push(result)
+		webhook.notify(result)
+		state.record(cfg, result)
+		recentRegions = rememberRegion(recentRegions, result, cfg.SpreadHistory)
+		if effect, ok := result["switch_effectiveness"].(map[string]any); ok {
+			verdicts[effect["verdict"].(string)]++
+		}
+		lastSwitch = nextSwitchContext(lastSwitch, result)
+		if result["action"] == "switched" {
+			lastSwitchAt = time.Now()
+		}
+		if history != nil {
+			history.record(result)
+			state.setEndpointTrends(history.summary())
+			if cfg.EndpointHistoryEvery > 0 && (cycle+1)%cfg.EndpointHistoryEvery == 0 {
+				history.logSummary()
 			}
-			fmt.Printf("switch_failed\t%s\t%s -> %dms\t%s\t(%s) err=%v\n", fromName, currentText, best.DelayMS, toName, reason, err)
+		}
+
+		var ok bool
+		if ok, late = waitForNextCycle(&cfg, sigCh, hupCh); !ok {
 			return
 		}
-		result := map[string]any{
-			"action":        "switched",
-			"from":          current,
-			"to":            best.Name,
-			"from_delay_ms": currentDelay,
-			"to_delay_ms":   best.DelayMS,
-			"reason":        reason,
-			"endpoints":     epSummary,
+	}
+}
+
+// resumedFromSuspend reports whether the wait before a cycle overran its
+// schedule by more than RESUME_SKIP_THRESHOLD_S, which on laptops means the
+// machine slept through it.
+func resumedFromSuspend(late time.Duration, cfg Config) bool {
+	return cfg.ResumeSkipThresholdS > 0 && late > time.Duration(cfg.ResumeSkipThresholdS)*time.Second
+}
+
+// wakeLateness is how far past expected the wake-up at woke came, by the wall
+// clock. Go timers run on the monotonic clock, which stops during suspend on
+// some systems, so the wall clock is what reveals the sleep.
+func wakeLateness(expected, woke time.Time) time.Duration {
+	return woke.Round(0).Sub(expected.Round(0))
+}
+
+// rememberRegion records the countries of a switch's from and to nodes in the
+// spread rotation history, keeping the last limit entries.
+func rememberRegion(recent []string, result map[string]any, limit int) []string {
+	if result["action"] != "switched" {
+		return recent
+	}
+	for _, key := range []string{"from", "to"} {
+		name, _ := result[key].(string)
+		region := inferRegion(name)
+		if region == "" || (len(recent) > 0 && recent[len(recent)-1] == region) {
+			continue
 		}
-		if jsonOutput {
-			fmt.Println(mustASCIIJSON(result))
-			return
+		recent = append(recent, region)
+	}
+	if len(recent) > limit {
+		recent = recent[len(recent)-limit:]
+	}
+	return recent
+}
+
+// alertText is the ALERT_ONLY recommendation autoSelectOnce adds to a
+// would_switch result before it is printed and sent to the sink.
+func alertText(from, to string) string {
+	return fmt.Sprintf("recommend switching from %s to %s", from, to)
+}
+
+// alertRecommendation raises the log line and desktop notification for an
+// ALERT_ONLY would_switch result. Other results stay silent.
+func alertRecommendation(cfg Config, result map[string]any) {
+	recommendation, ok := result["alert"].(string)
+	if !ok || result["action"] != "would_switch" {
+		return
+	}
+	from, _ := result["from"].(string)
+	to, _ := result["to"].(string)
+	reason, _ := result["reason"].(string)
+	logInfof("ALERT_ONLY: %s (%s)", recommendation, reason)
+	if cfg.DesktopNotify {
+		notifyDesktop("Proxy switch recommended", from, to, reason)
+	}
+}
+
+// waitForNextCycle sleeps for the monitor interval, reloading cfg on SIGHUP
+// (which restarts the wait with the new interval). It returns false on a
+// shutdown signal, and otherwise how late the wait ended.
+func waitForNextCycle(cfg *Config, sigCh, hupCh <-chan os.Signal) (bool, time.Duration) {
+	for {
+		interval := time.Duration(cfg.MonitorIntervalS) * time.Second
+		expected := time.Now().Add(interval)
+		timer := time.NewTimer(interval)
+		select {
+		case <-sigCh:
+			timer.Stop()
+			logInfof("Shutdown signal received")
+			return false, 0
+		case <-hupCh:
+			timer.Stop()
+			path := cfg.configFile
+			*cfg = reloadConfig(*cfg, func() (Config, error) { return loadConfigFile(path) })
+		case <-timer.C:
+			return true, wakeLateness(expected, time.Now())
 		}
-		fromName := sanitizeName(current)
-		toName := sanitizeName(best.Name)
-		currentText := "nil"
-		if currentDelay != nil {
-			currentText = fmt.Sprintf("%dms", *currentDelay)
+	}
+}
+
+// reloadConfig re-reads the configuration for SIGHUP. An invalid config keeps
+// the old one. Shared runtime state carries over, and settings bound at
+// startup keep their old values until restart.
+func reloadConfig(old Config, load func() (Config, error)) Config {
+	next, err := load()
+	if err == nil {
+		err = checkMonitorGroups(next)
+	}
+	if err != nil {
+		logErrorf("Config reload failed, keeping previous config: %v", err)
+		return old
+	}
+	next.controller = old.controller
+	next.exitIPs = old.exitIPs
+	next.probeOffset = old.probeOffset
+	next.delays = old.delays
+	next.configFile = old.configFile
+	if next.CacheTTLS != old.CacheTTLS {
+		logWarnf("Config reload: CACHE_TTL_S change requires a restart; keeping %d", old.CacheTTLS)
+		next.CacheTTLS = old.CacheTTLS
+	}
+	if next.ControllerInsecure != old.ControllerInsecure {
+		logWarnf("Config reload: CONTROLLER_INSECURE_SKIP_VERIFY change requires a restart; keeping %v", old.ControllerInsecure)
+		next.ControllerInsecure = old.ControllerInsecure
+	}
+	if next.WebhookBatchS != old.WebhookBatchS {
+		logWarnf("Config reload: WEBHOOK_BATCH_INTERVAL_S change requires a restart; keeping %d", old.WebhookBatchS)
+		next.WebhookBatchS = old.WebhookBatchS
+	}
+	for _, fixed := range []struct {
+		name     string
+		old, new *string
+	}{
+		{"ADMIN_ADDR", &old.AdminAddr, &next.AdminAddr},
+		{"METRICS_ADDR", &old.MetricsAddr, &next.MetricsAddr},
+		{"RESULT_SINK_URL", &old.ResultSinkURL, &next.ResultSinkURL},
+		{"NOTIFY_WEBHOOK_URL", &old.NotifyWebhookURL, &next.NotifyWebhookURL},
+		{"EVENT_FORMAT", &old.EventFormat, &next.EventFormat},
+		{"PID_FILE", &old.PIDFile, &next.PIDFile},
+		{"CONTROLLER_CA_FILE", &old.ControllerCAFile, &next.ControllerCAFile},
+	} {
+		if *fixed.old != *fixed.new {
+			logWarnf("Config reload: %s change requires a restart; keeping %q", fixed.name, *fixed.old)
+			*fixed.new = *fixed.old
 		}
-		fmt.Printf("switched\t%s\t%s -> %dms\t%s\t(%s)\n", fromName, currentText, best.DelayMS, toName, reason)
-		return
 	}
 
-	result := map[string]any{
-		"action":        "kept",
-		"current":       current,
-		"delay_ms":      currentDelay,
-		"best":          best.Name,
-		"best_delay_ms": best.DelayMS,
-		"reason":        reason,
-		"endpoints":     epSummary,
+	before, after := configSnapshot(old), configSnapshot(next)
+	keys := make([]string, 0, len(after))
+	for key := range after {
+		keys = append(keys, key)
 	}
-	if dryRun {
-		result["dry_run"] = true
+	sort.Strings(keys)
+	changed := 0
+	for _, key := range keys {
+		was, now := fmt.Sprint(before[key]), fmt.Sprint(after[key])
+		if was != now {
+			logInfof("Config reload: %s %s -> %s", key, was, now)
+			changed++
+		}
 	}
-	if jsonOutput {
-		fmt.Println(mustASCIIJSON(result))
-		return
+	logInfof("Config reloaded (%d setting(s) changed)", changed)
+	setLogLevel(next.LogLevel)
+	logControllerAuth(next)
+	return next
+}
+
+// getControllerStats reads one event from the streaming /traffic and /memory
+// endpoints; controllerRequest decodes the first JSON value and closes the
+// body, which ends the stream.
+func getControllerStats(client *http.Client, cfg Config) (map[string]any, error) {
+	traffic, err := controllerRequest(context.Background(), client, cfg, http.MethodGet, cfg.ControllerURL+"/traffic", nil)
+	if err != nil {
+		return nil, fmt.Errorf("traffic: %w", err)
 	}
-	currentText := "nil"
-	if currentDelay != nil {
-		currentText = fmt.Sprintf("%dms", *currentDelay)
+	memory, err := controllerRequest(context.Background(), client, cfg, http.MethodGet, cfg.ControllerURL+"/memory", nil)
+	if err != nil {
+		return nil, fmt.Errorf("memory: %w", err)
+	}
+	stats := map[string]any{}
+	for key, raw := range map[string]any{
+		"up_bps":               traffic["up"],
+		"down_bps":             traffic["down"],
+		"memory_inuse_bytes":   memory["inuse"],
+		"memory_oslimit_bytes": memory["oslimit"],
+	} {
+		if v, ok := toInt(raw); ok {
+			stats[key] = v
+		}
 	}
-	fmt.Printf("kept\t%s\t%s\t(%s)\n", currentText, sanitizeName(current), reason)
+	return stats, nil
 }
 
-func monitorLoop(client *http.Client, cfg Config, jsonOutput, dryRun bool) {
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	defer signal.Stop(sigCh)
+func formatBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	suffix := "KMGT"
+	i := -1
+	for value >= unit && i < len(suffix)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, suffix[i])
+}
 
-	for {
-		select {
-		case <-sigCh:
-			log.Printf("Shutdown signal received")
-			return
-		default:
+func printStatsOnce(client *http.Client, cfg Config, jsonOutput bool, format string) {
+	stats, err := getControllerStats(client, cfg)
+	if err != nil {
+		if jsonOutput || format != "" {
+			printJSON(map[string]any{"error": err.Error()})
+		} else {
+			fmt.Printf("Controller stats failed: %v\n", err)
 		}
+		return
+	}
+	if format == "grafana" {
+		printGrafanaRows(statsGrafanaRows(stats, time.Now()))
+		return
+	}
+	if jsonOutput {
+		printJSON(stats)
+		return
+	}
+	statText := func(key, suffix string) string {
+		v, ok := stats[key].(int)
+		if !ok {
+			return "n/a"
+		}
+		return formatBytes(v) + suffix
+	}
+	fmt.Printf("traffic\tup %s\tdown %s\n", statText("up_bps", "/s"), statText("down_bps", "/s"))
+	memoryText := statText("memory_inuse_bytes", "")
+	if limit, ok := stats["memory_oslimit_bytes"].(int); ok && limit > 0 {
+		memoryText += " / " + statText("memory_oslimit_bytes", "")
+	}
+	fmt.Printf("memory\t%s\n", memoryText)
+}
 
-		autoSelectOnce(client, cfg, jsonOutput, dryRun)
-
-		timer := time.NewTimer(time.Duration(cfg.MonitorIntervalS) * time.Second)
-		select {
-		case <-sigCh:
-			timer.Stop()
-			log.Printf("Shutdown signal received")
-			return
-		case <-timer.C:
+// checkCurrentEndpoints probes ENDPOINT_URLS through MIHOMO_PROXY_ADDR for
+// --check-endpoints, with the CHECK_DIRECT_BASELINE comparison if enabled.
+func checkCurrentEndpoints(cfg Config, dualStack bool) ([]EndpointResult, bool) {
+	var direct []EndpointResult
+	var wg sync.WaitGroup
+	if cfg.CheckDirectBaseline {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			direct = checkDirectEndpoints(context.Background(), cfg, cfg.EndpointURLs)
+		}()
+	}
+	var endpointResults []EndpointResult
+	if dualStack {
+		endpointResults = checkAllEndpointsDualStack(context.Background(), cfg, cfg.EndpointURLs)
+	} else {
+		endpointResults = checkAllEndpoints(context.Background(), cfg, cfg.EndpointURLs)
+	}
+	wg.Wait()
+	attachDirectBaseline(endpointResults, direct)
+	allReachable := true
+	for _, item := range endpointResults {
+		if !item.Reachable {
+			allReachable = false
+			break
 		}
 	}
+	return endpointResults, allReachable
 }
 
-func checkEndpointsCurrentOnce(client *http.Client, cfg Config, jsonOutput bool) {
+// checkEndpointsCurrentOnce prints endpoint health through the current proxy
+// and returns the --check-endpoints exit code: 0 when every endpoint is
+// reachable, 1 when any is not, 3 when nothing could be checked.
+func checkEndpointsCurrentOnce(client *http.Client, cfg Config, jsonOutput, dualStack bool) int {
 	current, currentFound := getCurrentProxy(client, cfg)
 
 	if len(cfg.EndpointURLs) == 0 {
 		if jsonOutput {
-			fmt.Println(mustASCIIJSON(map[string]any{"error": "ENDPOINT_URLS is empty"}))
+			printJSON(map[string]any{"error": "ENDPOINT_URLS is empty"})
 		} else {
 			fmt.Println("ENDPOINT_URLS is empty")
 		}
-		return
+		return endpointsUnchecked
 	}
 
 	if strings.TrimSpace(cfg.ProxyAddr) == "" {
 		if jsonOutput {
-			fmt.Println(mustASCIIJSON(map[string]any{"error": "MIHOMO_PROXY_ADDR is empty"}))
+			printJSON(map[string]any{"error": "MIHOMO_PROXY_ADDR is empty"})
 		} else {
 			fmt.Println("MIHOMO_PROXY_ADDR is empty")
 		}
-		return
-	}
-
-	endpointResults := checkAllEndpoints(cfg.ProxyAddr, cfg.EndpointURLs)
-	allReachable := true
-	for _, item := range endpointResults {
-		if !item.Reachable {
-			allReachable = false
-			break
-		}
+		return endpointsUnchecked
 	}
 
+	endpointResults, allReachable := checkCurrentEndpoints(cfg, dualStack)
 	if jsonOutput {
-		fmt.Println(mustASCIIJSON(map[string]any{
+		printJSON(map[string]any{
 			"current":       current,
 			"current_found": currentFound,
 			"all_reachable": allReachable,
 			"endpoints":     endpointResults,
-		}))
-		return
+		})
+		return endpointsExitCode(allReachable)
 	}
 
 	currentText := "unknown"
@@ -926,6 +3822,86 @@ func checkEndpointsCurrentOnce(client *http.Client, cfg Config, jsonOutput bool)
 	}
 	fmt.Printf("current\t%s\t%s\n", currentText, status)
 	for _, item := range endpointResults {
+		reachability := "unreachable"
+		if item.Reachable {
+			reachability = "reachable"
+		}
+		if item.DaysRemaining != nil {
+			fmt.Printf("%s\t%dms\t%s\tcert_expires=%s (%dd)\n", reachability, item.LatencyMS, item.URL, item.CertExpires, *item.DaysRemaining)
+		} else {
+			fmt.Printf("%s\t%dms\t%s\n", reachability, item.LatencyMS, item.URL)
+		}
+		if item.Redirected {
+			fmt.Printf("  redirected\t%d\t%s\n", item.StatusCode, item.FinalURL)
+		}
+		if item.ThroughputMbps != nil {
+			fmt.Printf("  throughput\t%.2fMbps\n", *item.ThroughputMbps)
+		}
+		if item.DirectLatencyMS != nil {
+			switch {
+			case *item.DirectLatencyMS < 0:
+				fmt.Printf("  direct\tunreachable\n")
+			case item.OverheadMS != nil:
+				fmt.Printf("  direct\t%dms\toverhead=%+dms\n", *item.DirectLatencyMS, *item.OverheadMS)
+			default:
+				fmt.Printf("  direct\t%dms\n", *item.DirectLatencyMS)
+			}
+		}
+		for _, family := range []struct {
+			label  string
+			result *EndpointResult
+		}{{"ipv4", item.IPv4}, {"ipv6", item.IPv6}} {
+			if family.result == nil {
+				continue
+			}
+			if family.result.Unsupported {
+				fmt.Printf("  %s\tunsupported through proxy\n", family.label)
+				continue
+			}
+			familyReachability := "unreachable"
+			if family.result.Reachable {
+				familyReachability = "reachable"
+			}
+			fmt.Printf("  %s\t%s\t%dms\n", family.label, familyReachability, family.result.LatencyMS)
+		}
+	}
+	return endpointsExitCode(allReachable)
+}
+
+func verifyProxyOnce(client *http.Client, cfg Config, proxyName string, jsonOutput bool) {
+	if len(cfg.EndpointURLs) == 0 {
+		if jsonOutput {
+			printJSON(map[string]any{"error": "ENDPOINT_URLS is empty"})
+		} else {
+			fmt.Println("ENDPOINT_URLS is empty")
+		}
+		return
+	}
+
+	results := verifyProxyEndpoints(context.Background(), client, cfg, proxyName, cfg.EndpointURLs)
+	allReachable := true
+	for _, item := range results {
+		if !item.Reachable {
+			allReachable = false
+			break
+		}
+	}
+
+	if jsonOutput {
+		printJSON(map[string]any{
+			"proxy":         proxyName,
+			"all_reachable": allReachable,
+			"endpoints":     results,
+		})
+		return
+	}
+
+	status := "ok"
+	if !allReachable {
+		status = "degraded"
+	}
+	fmt.Printf("proxy\t%s\t%s\n", sanitizeName(proxyName), status)
+	for _, item := range results {
 		reachability := "unreachable"
 		if item.Reachable {
 			reachability = "reachable"
@@ -934,14 +3910,127 @@ func checkEndpointsCurrentOnce(client *http.Client, cfg Config, jsonOutput bool)
 	}
 }
 
+// classifyPrune counts, per proxy, the samples it was missing from. Proxies
+// missing from every sample are dead; those missing from some are flaky.
+func classifyPrune(members []string, samples [][]ProxyDelay) (dead, flaky []PruneEntry) {
+	dead = make([]PruneEntry, 0)
+	flaky = make([]PruneEntry, 0)
+	if len(members) == 0 {
+		seen := make(map[string]bool)
+		for _, sample := range samples {
+			for _, item := range sample {
+				if !seen[item.Name] {
+					seen[item.Name] = true
+					members = append(members, item.Name)
+				}
+			}
+		}
+	}
+
+	for _, name := range members {
+		failures := 0
+		for _, sample := range samples {
+			found := false
+			for _, item := range sample {
+				if item.Name == name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				failures++
+			}
+		}
+		switch {
+		case failures == len(samples):
+			dead = append(dead, PruneEntry{Name: name, Failures: failures})
+		case failures > 0:
+			flaky = append(flaky, PruneEntry{Name: name, Failures: failures})
+		}
+	}
+	return dead, flaky
+}
+
+func pruneOnce(client *http.Client, cfg Config, sampleCount int, interval time.Duration, jsonOutput bool) {
+	members := []string{}
+	if info, err := getGroupInfo(context.Background(), client, cfg); err != nil {
+		logWarnf("Group member lookup failed, only nodes seen in samples are reported: %v", err)
+	} else {
+		members = info.All
+	}
+
+	samples := make([][]ProxyDelay, 0, sampleCount)
+	reason := ""
+	for i := 0; i < sampleCount; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		delays, sampleReason := getAllGroupDelaysWithReason(client, cfg)
+		if len(delays) == 0 {
+			reason = sampleReason
+			logWarnf("Prune sample %d/%d returned no delay data (%s), ignoring", i+1, sampleCount, reason)
+			continue
+		}
+		logInfof("Prune sample %d/%d: %d nodes responded", i+1, sampleCount, len(delays))
+		samples = append(samples, delays)
+	}
+
+	if len(samples) == 0 {
+		if jsonOutput {
+			printJSON(noDataResult(reason))
+		} else {
+			fmt.Println(noDataText(reason))
+		}
+		return
+	}
+
+	dead, flaky := classifyPrune(members, samples)
+	if jsonOutput {
+		printJSON(map[string]any{
+			"samples": len(samples),
+			"dead":    dead,
+			"flaky":   flaky,
+		})
+		return
+	}
+
+	for _, item := range dead {
+		fmt.Printf("dead\t%d/%d\t%s\n", item.Failures, len(samples), sanitizeName(item.Name))
+	}
+	for _, item := range flaky {
+		fmt.Printf("flaky\t%d/%d\t%s\n", item.Failures, len(samples), sanitizeName(item.Name))
+	}
+	if len(dead) == 0 && len(flaky) == 0 {
+		fmt.Printf("all nodes responded in %d samples\n", len(samples))
+	}
+}
+
 type CLIArgs struct {
-	PrintDelays    bool
-	JSONOutput     bool
-	PrintCurrent   bool
-	AutoSelect     bool
-	Monitor        bool
-	CheckEndpoints bool
-	DryRun         bool
+	PrintDelays     bool
+	JSONOutput      bool
+	CSVOutput       bool
+	PrintCurrent    bool
+	AutoSelect      bool
+	Monitor         bool
+	CheckEndpoints  bool
+	DryRun          bool
+	Histogram       bool
+	Buckets         []int
+	VerifyProxy     string
+	Prune           bool
+	Samples         int
+	Interval        time.Duration
+	Quiet           bool
+	DualStack       bool
+	Stats           bool
+	ExitIP          bool
+	TailHistory     bool
+	Watch           bool
+	Format          string
+	CheckController bool
+	ListGroups      bool
+	ServeHealth     bool
+	ConfigFile      string
 }
 
 func parseArgs() (CLIArgs, error) {
@@ -954,11 +4043,36 @@ func parseArgsFrom(argv []string) (CLIArgs, error) {
 	fs.SetOutput(io.Discard)
 	fs.BoolVar(&args.PrintDelays, "print-delays", false, "Print proxy delays for group and exit")
 	fs.BoolVar(&args.JSONOutput, "json", false, "Use JSON output when printing delays")
+	fs.BoolVar(&args.CSVOutput, "csv", false, "Use CSV output (with --print-delays or --check-endpoints)")
 	fs.BoolVar(&args.PrintCurrent, "print-current", false, "Print current proxy delay and exit")
 	fs.BoolVar(&args.AutoSelect, "auto-select", false, "Auto select faster proxy and exit")
 	fs.BoolVar(&args.Monitor, "monitor", false, "Run monitor loop with auto selection")
 	fs.BoolVar(&args.CheckEndpoints, "check-endpoints", false, "Test ENDPOINT_URLS via current proxy and exit")
 	fs.BoolVar(&args.DryRun, "dry-run", false, "Evaluate switching decision without applying proxy change")
+	fs.StringVar(&args.VerifyProxy, "verify-proxy", "", "Test ENDPOINT_URLS through the named proxy via the controller and exit")
+	fs.BoolVar(&args.Stats, "stats", false, "Print controller traffic and memory usage and exit")
+	fs.BoolVar(&args.CheckController, "check-controller", false, "Check controller reachability, latency and auth and exit")
+	fs.BoolVar(&args.ListGroups, "list-groups", false, "List the controller's proxy groups with their current node and exit")
+	fs.StringVar(&args.ConfigFile, "config", "", "JSON file with settings; environment variables and .env take precedence")
+	fs.BoolVar(&args.ServeHealth, "serve-health", false, "Serve /healthz on HEALTH_ADDR until interrupted")
+	fs.BoolVar(&args.ExitIP, "exit-ip", false, "Print the exit IP and country of the current proxy and exit")
+	fs.BoolVar(&args.TailHistory, "tail-history", false, "Follow HISTORY_FILE and print decisions as they are appended")
+	fs.BoolVar(&args.Watch, "watch", false, "Sample group delays every --interval and print rolling percentiles")
+	fs.BoolVar(&args.Prune, "prune", false, "Sample group delays repeatedly and report dead nodes")
+	fs.IntVar(&args.Samples, "samples", 10, "Number of samples for --prune")
+	fs.DurationVar(&args.Interval, "interval", 10*time.Second, "Time between samples for --prune and --watch")
+	fs.BoolVar(&args.Quiet, "quiet", false, "Suppress stdout and report the decision via exit code (with --auto-select)")
+	fs.BoolVar(&args.DualStack, "dual-stack", false, "Probe each endpoint over IPv4 and IPv6 separately (with --check-endpoints)")
+	fs.StringVar(&args.Format, "format", "", "Alternative output format: grafana (with --stats or --histogram)")
+	fs.BoolVar(&args.Histogram, "histogram", false, "Summarize delays as bucket counts (with --print-delays)")
+	fs.Func("buckets", "Comma-separated histogram bucket boundaries in ms", func(v string) error {
+		bounds, err := parseBucketBounds(v)
+		if err != nil {
+			return err
+		}
+		args.Buckets = bounds
+		return nil
+	})
 	if err := fs.Parse(argv); err != nil {
 		return CLIArgs{}, err
 	}
@@ -979,20 +4093,145 @@ func parseArgsFrom(argv []string) (CLIArgs, error) {
 	if args.CheckEndpoints {
 		actionCount++
 	}
+	if args.VerifyProxy != "" {
+		actionCount++
+	}
+	if args.Prune {
+		actionCount++
+	}
+	if args.Stats && !args.PrintDelays {
+		actionCount++
+	}
+	if args.ExitIP {
+		actionCount++
+	}
+	if args.TailHistory {
+		actionCount++
+	}
+	if args.Watch {
+		actionCount++
+	}
+	if args.CheckController {
+		actionCount++
+	}
+	if args.ListGroups {
+		actionCount++
+	}
+	if args.ServeHealth {
+		actionCount++
+	}
 
 	if actionCount != 1 {
-		return CLIArgs{}, errors.New("exactly one of --print-delays, --print-current, --auto-select, --monitor, --check-endpoints is required")
+		return CLIArgs{}, errors.New("exactly one of --print-delays, --print-current, --auto-select, --monitor, --check-endpoints, --verify-proxy, --prune, --stats, --exit-ip, --tail-history, --watch, --check-controller, --list-groups, --serve-health is required")
 	}
 	if args.DryRun && !(args.AutoSelect || args.Monitor) {
 		return CLIArgs{}, errors.New("--dry-run can only be used with --auto-select or --monitor")
 	}
+	if args.DualStack && !args.CheckEndpoints {
+		return CLIArgs{}, errors.New("--dual-stack can only be used with --check-endpoints")
+	}
+	if args.Quiet && args.JSONOutput {
+		return CLIArgs{}, errors.New("--quiet and --json cannot be used together")
+	}
+	if args.CSVOutput && args.JSONOutput {
+		return CLIArgs{}, errors.New("--csv and --json cannot be used together")
+	}
+	if args.CSVOutput && args.DualStack {
+		return CLIArgs{}, errors.New("--csv cannot be used with --dual-stack")
+	}
+	if args.CSVOutput && !((args.PrintDelays && !args.Histogram && !args.Stats) || args.CheckEndpoints) {
+		return CLIArgs{}, errors.New("--csv can only be used with --print-delays (without --histogram or --stats) or --check-endpoints")
+	}
+	if args.Quiet && !args.AutoSelect {
+		return CLIArgs{}, errors.New("--quiet can only be used with --auto-select")
+	}
+	samplesSet, intervalSet := false, false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "samples":
+			samplesSet = true
+		case "interval":
+			intervalSet = true
+		}
+	})
+	if (samplesSet && !args.Prune) || (intervalSet && !(args.Prune || args.Watch)) {
+		return CLIArgs{}, errors.New("--samples and --interval can only be used with --prune (--interval also with --watch)")
+	}
+	if args.Samples <= 0 {
+		return CLIArgs{}, errors.New("--samples must be > 0")
+	}
+	if args.Interval < 0 {
+		return CLIArgs{}, errors.New("--interval must be >= 0")
+	}
+	if args.Watch && args.Interval == 0 {
+		return CLIArgs{}, errors.New("--interval must be > 0 with --watch")
+	}
+	if args.Stats && args.PrintDelays && (args.Histogram || args.Format != "") {
+		return CLIArgs{}, errors.New("--stats with --print-delays cannot be combined with --histogram or --format")
+	}
+	if args.Histogram && !args.PrintDelays {
+		return CLIArgs{}, errors.New("--histogram can only be used with --print-delays")
+	}
+	if args.Buckets != nil && !args.Histogram {
+		return CLIArgs{}, errors.New("--buckets can only be used with --histogram")
+	}
+	if args.Format != "" && args.Format != "grafana" {
+		return CLIArgs{}, fmt.Errorf("--format must be grafana; got %q", args.Format)
+	}
+	if args.Format != "" && !(args.Stats || args.Histogram) {
+		return CLIArgs{}, errors.New("--format can only be used with --stats or --histogram")
+	}
+	if args.Histogram && args.Buckets == nil {
+		args.Buckets = defaultHistogramBounds
+	}
 	return args, nil
 }
 
+// --check-endpoints exit codes beyond 0 (all reachable).
+const (
+	endpointsDegraded  = 1
+	endpointsUnchecked = 3
+)
+
+func endpointsExitCode(allReachable bool) int {
+	if allReachable {
+		return 0
+	}
+	return endpointsDegraded
+}
+
+// decisionExitCode maps an auto-select result to the --quiet exit status.
+func decisionExitCode(result map[string]any) int {
+	if _, failed := result["error"]; failed {
+		return 1
+	}
+	switch result["action"] {
+	case "switched", "would_switch":
+		return 3
+	case "switch_failed":
+		return 1
+	}
+	return 0
+}
+
 func usageText() string {
 	return strings.TrimSpace(`
 Usage:
+  mihomo-monitor [--config settings.json] <action> ...
   mihomo-monitor [--json] [--dry-run] (--print-delays | --print-current | --auto-select | --monitor | --check-endpoints)
+  mihomo-monitor [--json] --print-delays --histogram [--buckets 100,300,1000]
+  mihomo-monitor [--json] --print-delays --stats
+  mihomo-monitor --csv (--print-delays | --check-endpoints)
+  mihomo-monitor --format grafana (--stats | --print-delays --histogram)
+  mihomo-monitor [--json] --verify-proxy <name>
+  mihomo-monitor [--json] --prune [--samples 10] [--interval 10s]
+  mihomo-monitor --quiet [--dry-run] --auto-select
+  mihomo-monitor [--json] --check-endpoints --dual-stack
+  mihomo-monitor [--json] --tail-history
+  mihomo-monitor [--json] --watch [--interval 10s]
+  mihomo-monitor [--json] --check-controller
+  mihomo-monitor [--json] --list-groups
+  mihomo-monitor --serve-health
 
 Flags:
   --print-delays     Print top 10 proxy delays for group and exit
@@ -1000,8 +4239,26 @@ Flags:
   --auto-select      Evaluate and switch proxy once
   --monitor          Run monitor loop with auto selection
   --check-endpoints  Test ENDPOINT_URLS via current proxy and exit
+  --verify-proxy     Test ENDPOINT_URLS through the named proxy and exit
+  --prune            Sample delays repeatedly and report dead/flaky nodes
+  --stats            Print controller traffic rates and memory usage and exit; with --print-delays, add a delay summary
+  --exit-ip          Print exit IP/country via MIHOMO_PROXY_ADDR and exit
+  --tail-history     Follow HISTORY_FILE and print decisions as they are appended
+  --watch            Sample delays every --interval and print rolling p50/p95
+  --check-controller Check controller reachability, latency and secret; exit 1 on failure
+  --list-groups      List proxy groups (name, type, current node) and exit
+  --serve-health     Serve /healthz on HEALTH_ADDR: 200 if the current node is within KEEP_DELAY_THRESHOLD_MS, else 503
+  --samples          Number of --prune samples (default: 10)
+  --interval         Time between --prune/--watch samples (default: 10s)
+  --config           JSON file of settings (env var names as keys); env and .env take precedence
   --json             Use JSON output
+  --csv              Only with --print-delays/--check-endpoints; CSV with a header row
   --dry-run          Only with --auto-select/--monitor; never apply switch
+  --quiet            Only with --auto-select; no stdout, exit 0 kept/skipped, 3 switched, 1 failed
+  --dual-stack       Only with --check-endpoints; also probe over IPv4 and IPv6 only
+  --histogram        Only with --print-delays; print delay bucket counts
+  --buckets          Histogram bucket boundaries in ms (default: 100,300,1000)
+  --format           Only with --stats/--histogram; grafana prints flat {metric,value,time} rows
 `)
 }
 
@@ -1019,29 +4276,177 @@ func main() {
 		os.Exit(0)
 	}
 
-	cfg, err := loadConfig()
+	cfg, err := loadConfigFile(args.ConfigFile)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
+	setLogLevel(cfg.LogLevel)
+	logControllerAuth(cfg)
 
-	baseTransport, err := buildBaseTransportNoEnvProxy()
+	baseTransport, err := buildControllerTransport(cfg)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
 	client := &http.Client{Transport: baseTransport}
 
+	if args.Monitor {
+		if err := checkMonitorGroups(cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+	if (args.AutoSelect || args.Monitor) && !cfg.SkipGroupValidation {
+		if err := validateProxyGroup(client, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// exit skips deferred calls, so once the PID file is written it is
+	// removed explicitly before every exit.
+	exit := os.Exit
+	if cfg.PIDFile != "" && args.Monitor {
+		if err := writePIDFile(cfg.PIDFile, cfg.ForceStart); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		defer removePIDFile(cfg.PIDFile)
+		exit = func(code int) {
+			removePIDFile(cfg.PIDFile)
+			os.Exit(code)
+		}
+	}
+
+	if args.Quiet {
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			exit(1)
+		}
+		os.Stdout = devNull
+	}
+
+	var sink *resultSink
+	if cfg.ResultSinkURL != "" && (args.AutoSelect || args.Monitor) {
+		sink, err = newResultSink(cfg.ResultSinkURL, cfg.EventFormat)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			exit(1)
+		}
+		defer sink.close(resultSinkTimeout)
+	}
+	var webhook *switchWebhook
+	if cfg.NotifyWebhookURL != "" && (args.AutoSelect || args.Monitor) {
+		webhook, err = newSwitchWebhook(cfg.NotifyWebhookURL, cfg.EventFormat, time.Duration(cfg.WebhookBatchS)*time.Second)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			exit(1)
+		}
+		defer webhook.close(webhookTimeout)
+	}
+
 	switch {
+	case args.PrintDelays && args.Stats:
+		printDelayStatsOnce(client, cfg, args.JSONOutput)
+	case args.PrintDelays && args.Histogram:
+		printDelayHistogramOnce(client, cfg, args.Buckets, args.JSONOutput, args.Format)
+	case args.PrintDelays && args.CSVOutput:
+		printDelaysCSV(client, cfg)
+	case args.PrintDelays && len(cfg.ProxyGroups) > 1:
+		printDelaysGroups(client, cfg, args.JSONOutput)
 	case args.PrintDelays:
 		printDelaysOnce(client, cfg, args.JSONOutput)
 	case args.PrintCurrent:
 		printCurrentDelayOnce(client, cfg, args.JSONOutput)
 	case args.AutoSelect:
-		autoSelectOnce(client, cfg, args.JSONOutput, args.DryRun)
+		results := autoSelectGroups(client, cfg, args.JSONOutput, args.DryRun)
+		for _, result := range results {
+			sink.push(result)
+			webhook.notify(result)
+		}
+		if args.Quiet {
+			sink.close(resultSinkTimeout)
+			webhook.close(webhookTimeout)
+			exit(groupsExitCode(results))
+		}
 	case args.Monitor:
-		monitorLoop(client, cfg, args.JSONOutput, args.DryRun)
+		cfg.exitIPs = newExitIPCache()
+		var state *monitorState
+		if cfg.AdminAddr != "" && cfg.CacheTTLS > 0 {
+			var stopCache func()
+			cfg.delays, stopCache = startDelayCache(client, cfg)
+			defer stopCache()
+		}
+		if cfg.AdminAddr != "" || cfg.MetricsAddr != "" {
+			state = newMonitorState(cfg)
+		}
+		if cfg.AdminAddr != "" {
+			closeAdmin, err := startAdminServer(cfg.AdminAddr, state)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				exit(1)
+			}
+			defer closeAdmin()
+		}
+		if cfg.MetricsAddr != "" {
+			closeMetrics, err := startMetricsServer(cfg.MetricsAddr, state)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				exit(1)
+			}
+			defer closeMetrics()
+		}
+		monitorLoop(client, cfg, sink, webhook, state, args.JSONOutput, args.DryRun)
+	case args.CheckEndpoints && args.CSVOutput:
+		if code := checkEndpointsCSVOnce(cfg, args.DualStack); code != 0 {
+			exit(code)
+		}
 	case args.CheckEndpoints:
-		checkEndpointsCurrentOnce(client, cfg, args.JSONOutput)
+		if code := checkEndpointsCurrentOnce(client, cfg, args.JSONOutput, args.DualStack); code != 0 {
+			exit(code)
+		}
+	case args.VerifyProxy != "":
+		verifyProxyOnce(client, cfg, args.VerifyProxy, args.JSONOutput)
+	case args.Prune:
+		pruneOnce(client, cfg, args.Samples, args.Interval, args.JSONOutput)
+	case args.Stats:
+		printStatsOnce(client, cfg, args.JSONOutput, args.Format)
+	case args.ExitIP:
+		printExitIPOnce(client, cfg, args.JSONOutput)
+	case args.CheckController:
+		exit(checkControllerOnce(client, cfg, args.JSONOutput))
+	case args.ListGroups:
+		exit(listGroupsOnce(client, cfg, args.JSONOutput))
+	case args.ServeHealth:
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		if code := serveHealthOnce(client, cfg, stop); code != 0 {
+			exit(code)
+		}
+	case args.Watch:
+		if cfg.CacheTTLS > 0 {
+			var stopCache func()
+			cfg.delays, stopCache = startDelayCache(client, cfg)
+			defer stopCache()
+		}
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		watchDelays(client, cfg, args.Interval, args.JSONOutput, stop)
+	case args.TailHistory:
+		if cfg.HistoryFile == "" {
+			fmt.Fprintln(os.Stderr, "HISTORY_FILE is required for --tail-history")
+			exit(1)
+		}
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		if err := tailHistory(cfg, os.Stdout, args.JSONOutput, stop); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			exit(1)
+		}
 	}
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recordGauges updates the current delay and endpoint gauges from a result.
+// Results that measured nothing, such as skipped cycles, leave them as they
+// were. Callers hold s.mu.
+func (s *monitorState) recordGauges(action string, result map[string]any) {
+	var delayKey string
+	switch action {
+	case "kept":
+		delayKey = "delay_ms"
+	case "switched":
+		delayKey = "to_delay_ms"
+	case "would_switch", "switch_failed":
+		delayKey = "from_delay_ms"
+	default:
+		return
+	}
+	s.currentDelay = nil
+	if value := result[delayKey]; value != nil {
+		if ptr, ok := value.(*int); ok {
+			if ptr != nil {
+				delayMS := *ptr
+				s.currentDelay = &delayMS
+			}
+		} else if delayMS, ok := toInt(value); ok {
+			s.currentDelay = &delayMS
+		}
+	}
+	if endpoints, ok := result["endpoints"].([]map[string]any); ok {
+		s.endpoints = make(map[string]bool, len(endpoints))
+		for _, entry := range endpoints {
+			endpointURL, _ := entry["url"].(string)
+			reachable, _ := entry["reachable"].(bool)
+			s.endpoints[endpointURL] = reachable
+		}
+	}
+}
+
+// writeMetrics renders the state in the Prometheus text exposition format.
+func (s *monitorState) writeMetrics(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("mihomo_monitor_cycles_total", "counter", "Monitor cycles run.")
+	fmt.Fprintf(w, "mihomo_monitor_cycles_total %d\n", s.cycles)
+
+	metric("mihomo_decisions_total", "counter", "Monitor cycle results by action.")
+	for _, action := range sortedKeys(s.actions) {
+		fmt.Fprintf(w, "mihomo_decisions_total{action=\"%s\"} %d\n", promLabel(action), s.actions[action])
+	}
+
+	metric("mihomo_switch_total", "counter", "Successful switches by type.")
+	for _, switchType := range []string{"emergency", "performance"} {
+		fmt.Fprintf(w, "mihomo_switch_total{type=\"%s\"} %d\n", switchType, s.switches[switchType])
+	}
+
+	if !s.lastSwitch.IsZero() {
+		metric("mihomo_last_switch_timestamp_seconds", "gauge", "Unix time of the last successful switch.")
+		fmt.Fprintf(w, "mihomo_last_switch_timestamp_seconds %d\n", s.lastSwitch.Unix())
+	}
+
+	if s.currentDelay != nil {
+		metric("mihomo_current_delay_ms", "gauge", "Delay of the selected node in the last evaluated cycle.")
+		fmt.Fprintf(w, "mihomo_current_delay_ms %d\n", *s.currentDelay)
+	}
+
+	if len(s.endpoints) > 0 {
+		metric("mihomo_endpoint_reachable", "gauge", "Whether each ENDPOINT_URLS entry was reachable in the last evaluated cycle.")
+		urls := make([]string, 0, len(s.endpoints))
+		for endpointURL := range s.endpoints {
+			urls = append(urls, endpointURL)
+		}
+		sort.Strings(urls)
+		for _, endpointURL := range urls {
+			value := 0
+			if s.endpoints[endpointURL] {
+				value = 1
+			}
+			fmt.Fprintf(w, "mihomo_endpoint_reachable{url=\"%s\"} %d\n", promLabel(endpointURL), value)
+		}
+	}
+}
+
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabel(value string) string {
+	return promLabelEscaper.Replace(value)
+}
+
+func metricsHandler(state *monitorState) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		state.writeMetrics(w)
+	})
+	return mux
+}
+
+// startMetricsServer serves /metrics on METRICS_ADDR until close is called.
+// Unlike ADMIN_ADDR, a bare ":port" listens on all interfaces so a remote
+// Prometheus can scrape it.
+func startMetricsServer(addr string, state *monitorState) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("METRICS_ADDR: %w", err)
+	}
+	server := &http.Server{Handler: metricsHandler(state), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logErrorf("Metrics server stopped: %v", err)
+		}
+	}()
+	logInfof("Metrics endpoint listening on http://%s/metrics", listener.Addr())
+	return func() { _ = server.Close() }, nil
+}
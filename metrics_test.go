@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	cfg := Config{ProxyGroup: "PROXY"}
+	state := newMonitorState(cfg)
+	before := 900
+	state.record(cfg, map[string]any{
+		"action": "switched", "switch_type": "performance", "from": "A", "to": "B",
+		"from_delay_ms": &before, "to_delay_ms": 120,
+		"endpoints": []map[string]any{{"url": "https://a.example/", "reachable": true}, {"url": `https://b.example/"x"`, "reachable": false}},
+	})
+	state.record(cfg, map[string]any{"action": "skipped", "reason": "paused"})
+
+	server := httptest.NewServer(metricsHandler(state))
+	t.Cleanup(server.Close)
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %v", err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+	body := string(raw)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"mihomo_monitor_cycles_total 2\n",
+		"mihomo_decisions_total{action=\"skipped\"} 1\n",
+		"mihomo_switch_total{type=\"performance\"} 1\n",
+		"mihomo_switch_total{type=\"emergency\"} 0\n",
+		"mihomo_current_delay_ms 120\n",
+		"mihomo_endpoint_reachable{url=\"https://a.example/\"} 1\n",
+		"mihomo_endpoint_reachable{url=\"https://b.example/\\\"x\\\"\"} 0\n",
+		"# TYPE mihomo_last_switch_timestamp_seconds gauge\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics missing %q:\n%s", want, body)
+		}
+	}
+
+	state.record(cfg, map[string]any{"action": "kept", "current": "B", "delay_ms": (*int)(nil), "endpoints": []map[string]any{}})
+	var out strings.Builder
+	state.writeMetrics(&out)
+	if strings.Contains(out.String(), "mihomo_current_delay_ms") || strings.Contains(out.String(), "mihomo_endpoint_reachable") {
+		t.Fatalf("expected unknown delay and no endpoints to drop the gauges:\n%s", out.String())
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const desktopNotifyTimeout = 5 * time.Second
+
+// desktopNotifyCommand builds the platform notifier invocation, or returns an
+// empty name when the platform has no supported notifier.
+func desktopNotifyCommand(goos, title, body string) (string, []string) {
+	switch goos {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return "notify-send", []string{"--app-name=mihomo-monitor", title, body}
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptQuote(body), appleScriptQuote(title))
+		return "osascript", []string{"-e", script}
+	}
+	return "", nil
+}
+
+func appleScriptQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// notifyDesktop shows a switch notification. The notifier is started before
+// returning, so a one-shot --auto-select that exits right after still shows
+// it; waiting for it happens in the background. Failures are logged and never
+// delay the caller.
+func notifyDesktop(title, from, to, reason string) {
+	name, args := desktopNotifyCommand(runtime.GOOS, title, fmt.Sprintf("%s -> %s\n%s", from, to, reason))
+	if name == "" {
+		logWarnf("DESKTOP_NOTIFY: no notifier supported on %s; skipping", runtime.GOOS)
+		return
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		logWarnf("DESKTOP_NOTIFY: %s not found; skipping", name)
+		return
+	}
+	cmd := exec.Command(path, args...)
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		logWarnf("DESKTOP_NOTIFY: %v", err)
+		return
+	}
+	go func() {
+		timer := time.AfterFunc(desktopNotifyTimeout, func() { _ = cmd.Process.Kill() })
+		defer timer.Stop()
+		if err := cmd.Wait(); err != nil {
+			logWarnf("DESKTOP_NOTIFY: %s failed: %v", name, err)
+		}
+	}()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDesktopNotifyCommand(t *testing.T) {
+	name, args := desktopNotifyCommand("linux", "Proxy switched", "A -> B")
+	if name != "notify-send" || !reflect.DeepEqual(args, []string{"--app-name=mihomo-monitor", "Proxy switched", "A -> B"}) {
+		t.Fatalf("unexpected linux command: %s %v", name, args)
+	}
+
+	name, args = desktopNotifyCommand("darwin", "Proxy switched", `say "hi" \o/`)
+	want := `display notification "say \"hi\" \\o/" with title "Proxy switched"`
+	if name != "osascript" || len(args) != 2 || args[0] != "-e" || args[1] != want {
+		t.Fatalf("unexpected darwin command: %s %v", name, args)
+	}
+
+	if name, _ := desktopNotifyCommand("windows", "t", "b"); name != "" {
+		t.Fatalf("expected no notifier on windows, got %q", name)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// defaultOutputTemplate renders the human-readable auto-select output when
+// OUTPUT_TEMPLATE is unset; it is the starting point documented for
+// OUTPUT_TEMPLATE.
+const defaultOutputTemplate = `{{$a := print .action -}}
+{{if eq $a "switched" "would_switch" "switch_failed" -}}
+{{if eq $a "would_switch"}}would_switch(dry-run){{else}}{{$a}}{{end}}	{{name .from}}	{{delay .from_delay_ms}} -> {{delay .to_delay_ms}}	{{name .to}}	({{.reason}}){{if eq $a "switch_failed"}} err={{.error}}{{end}}
+{{- with .candidate_endpoint_results}}	candidate endpoints {{reachable .}}/{{len .}} reachable{{end}}
+{{- else if eq $a "kept" -}}
+kept	{{delay .delay_ms}}	{{name .current}}	({{.reason}})
+{{- else if eq $a "skipped" -}}
+skipped	({{.reason}})
+{{- else -}}
+No delay data returned{{with .message}} ({{.}}){{end}}
+{{- end}}`
+
+// compileOutputTemplate parses an OUTPUT_TEMPLATE. Templates see the result
+// map and three helpers: name (sanitized proxy name), delay (a delay field
+// formatted per OUTPUT_DELAY_UNIT, "nil" when absent) and reachable (the
+// number of reachable endpoint results in a list). A field missing from
+// the map prints as "<no value>"; templates guard it with or/with.
+func compileOutputTemplate(src string, delayUnit string) (*template.Template, error) {
+	unitCfg := Config{OutputDelayUnit: delayUnit}
+	funcs := template.FuncMap{
+		"name": func(v any) string {
+			s, _ := v.(string)
+			return sanitizeName(s)
+		},
+		"delay": func(v any) string {
+			if p, ok := v.(*int); ok {
+				if p == nil {
+					return "nil"
+				}
+				v = *p
+			}
+			ms, ok := toInt(v)
+			if !ok {
+				return "nil"
+			}
+			return formatDelay(ms, unitCfg)
+		},
+		"reachable": func(results []EndpointResult) int {
+			n := 0
+			for _, item := range results {
+				if item.Reachable {
+					n++
+				}
+			}
+			return n
+		},
+	}
+	return template.New("output").Funcs(funcs).Parse(src)
+}
+
+var defaultTemplates sync.Map
+
+// defaultTemplate is defaultOutputTemplate compiled for delayUnit.
+func defaultTemplate(delayUnit string) *template.Template {
+	if tmpl, ok := defaultTemplates.Load(delayUnit); ok {
+		return tmpl.(*template.Template)
+	}
+	tmpl := template.Must(compileOutputTemplate(defaultOutputTemplate, delayUnit))
+	defaultTemplates.Store(delayUnit, tmpl)
+	return tmpl
+}
+
+func renderOutputTemplate(tmpl *template.Template, result map[string]any) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, result); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDefaultOutputTemplateText(t *testing.T) {
+	fc := &fakeController{
+		now:         "A",
+		groupDelays: map[string]any{"A": 2500, "B": 100},
+	}
+	server := newFakeController(t, fc)
+	cfg := Config{
+		ControllerURL:        server.URL,
+		ProxyGroup:           "PROXY",
+		TestURL:              "https://example.com",
+		DelayTimeoutMS:       3000,
+		AutoSelectDiffMS:     300,
+		KeepDelayThresholdMS: 2000,
+	}
+
+	runs := []struct {
+		name string
+		run  func(cfg Config)
+		want string
+	}{
+		{"would_switch", func(cfg Config) { autoSelectOnce(context.Background(), server.Client(), cfg, false, true) },
+			"would_switch(dry-run)\tA\t2500ms -> 100ms\tB\t(delay 2500ms > 2000ms and best is 2400ms faster)\n"},
+		{"kept", func(cfg Config) {
+			cfg.KeepDelayThresholdMS = 3000
+			autoSelectOnce(context.Background(), server.Client(), cfg, false, true)
+		}, "kept\t2500ms\tA\t(endpoints ok, delay 2500ms <= 3000ms threshold)\n"},
+		{"skipped", func(cfg Config) { emitSkipped(cfg, "gated", false) }, "skipped\t(gated)\n"},
+		{"switch_failed", func(cfg Config) {
+			emitResult(context.Background(), cfg, map[string]any{
+				"action": "switch_failed", "from": "A", "to": "B", "from_delay_ms": nil,
+				"to_delay_ms": 100, "reason": "r", "error": "boom",
+			}, false)
+		}, "switch_failed\tA\tnil -> 100ms\tB\t(r) err=boom\n"},
+		{"candidates", func(cfg Config) {
+			emitResult(context.Background(), cfg, map[string]any{
+				"action": "would_switch", "from": "A", "to": "B", "from_delay_ms": 2500,
+				"to_delay_ms": 100, "reason": "r",
+				"candidate_endpoint_results": []EndpointResult{{Reachable: true}, {}},
+			}, false)
+		}, "would_switch(dry-run)\tA\t2500ms -> 100ms\tB\t(r)\tcandidate endpoints 1/2 reachable\n"},
+		{"error", func(cfg Config) {
+			emitResult(context.Background(), cfg, map[string]any{"error": "no delay data"}, false)
+		}, "No delay data returned\n"},
+		{"no_data", func(cfg Config) { emitResult(context.Background(), cfg, noDataResult(noDataAllTimedOut), false) }, noDataText(noDataAllTimedOut) + "\n"},
+	}
+	for _, tc := range runs {
+		if got := string(captureStdout(t, func() { tc.run(cfg) })); got != tc.want {
+			t.Fatalf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestOutputTemplateCustomAndInvalid(t *testing.T) {
+	tmpl, err := compileOutputTemplate(`{{.action}}: {{name .current}} at {{delay .delay_ms}}`, "s")
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	delay := 1500
+	out, err := renderOutputTemplate(tmpl, map[string]any{"action": "kept", "current": "JP\x01-1", "delay_ms": &delay})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if out != "kept: JP-1 at 1.5s" {
+		t.Fatalf("unexpected render: %q", out)
+	}
+
+	tmpl, err = compileOutputTemplate(`{{.action}} [{{.to}}] [{{or .to ""}}] [{{name .to}}]`, "ms")
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	out, err = renderOutputTemplate(tmpl, map[string]any{"action": "kept", "current": "JP-1"})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if out != "kept [<no value>] [] []" {
+		t.Fatalf("unexpected render with a missing field: %q", out)
+	}
+
+	if _, err := compileOutputTemplate(`{{.action`, "ms"); err == nil {
+		t.Fatalf("expected invalid template to be rejected")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// writePIDFile records the current PID at path. The file is created with
+// O_EXCL, so of two instances starting together only one gets it. An existing
+// file naming a live process is an error unless force is set; stale files are
+// removed and the create is retried once. An empty file is another instance
+// that has created but not yet written it.
+func writePIDFile(path string, force bool) error {
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err = file.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				_ = os.Remove(path)
+				return fmt.Errorf("write PID_FILE: %w", err)
+			}
+			return nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("create PID_FILE: %w", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("read PID_FILE: %w", err)
+		}
+		if !force {
+			content := strings.TrimSpace(string(data))
+			if err == nil && content == "" {
+				return fmt.Errorf("PID_FILE %s is being written by another instance; set FORCE_START=true to start anyway", path)
+			}
+			pid, perr := strconv.Atoi(content)
+			if perr == nil && pid > 0 && pid != os.Getpid() && processAlive(pid) {
+				return fmt.Errorf("PID_FILE %s names running process %d; set FORCE_START=true to start anyway", path, pid)
+			}
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove stale PID_FILE: %w", err)
+		}
+	}
+	return fmt.Errorf("PID_FILE %s was recreated by another instance during startup", path)
+}
+
+// removePIDFile deletes path if it still names this process, so a forced
+// second instance does not lose its file when the first exits.
+func removePIDFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	_ = os.Remove(path)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWritePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitor.pid")
+	self := strconv.Itoa(os.Getpid())
+
+	// A PID that cannot exist is stale and gets overwritten.
+	if err := os.WriteFile(path, []byte("999999999\n"), 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if err := writePIDFile(path, false); err != nil {
+		t.Fatalf("expected stale PID file to be overwritten, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); strings.TrimSpace(string(data)) != self {
+		t.Fatalf("expected own PID in file, got %q", data)
+	}
+
+	// The parent process is alive, so it blocks startup unless forced.
+	parent := strconv.Itoa(os.Getppid())
+	if err := os.WriteFile(path, []byte(parent), 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if err := writePIDFile(path, false); err == nil || !strings.Contains(err.Error(), "FORCE_START") {
+		t.Fatalf("expected live PID refusal, got %v", err)
+	}
+	if err := writePIDFile(path, true); err != nil {
+		t.Fatalf("expected FORCE_START to override, got %v", err)
+	}
+
+	// An empty file belongs to an instance that is still starting up.
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if err := writePIDFile(path, false); err == nil || !strings.Contains(err.Error(), "another instance") {
+		t.Fatalf("expected an empty PID file to block startup, got %v", err)
+	}
+	if err := writePIDFile(path, true); err != nil {
+		t.Fatalf("expected FORCE_START to override an empty file, got %v", err)
+	}
+
+	removePIDFile(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected PID file removed, stat err=%v", err)
+	}
+
+	// A file naming another process is left alone.
+	if err := os.WriteFile(path, []byte(parent), 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	removePIDFile(path)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected foreign PID file kept, stat err=%v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// reliabilityWindow is the number of --monitor cycles SELECT_STRATEGY=reliable
+// remembers per node.
+const reliabilityWindow = 20
+
+// reliability is the Laplace-smoothed fraction of recorded cycles in which the
+// node answered, (answered+1)/(cycles+2): an unknown node scores 0.5 and a
+// node's score approaches its observed rate as cycles accumulate.
+func reliability(window *delayWindow, name string) float64 {
+	var samples []int
+	if window != nil {
+		samples = window.samples[name]
+	}
+	return float64(len(answeredSamples(samples))+1) / float64(len(samples)+2)
+}
+
+// reliabilityScore blends delay, scaled to [0,1] by DELAY_TIMEOUT_MS, with
+// unreliability; weight is RELIABILITY_WEIGHT. Lower is better.
+func reliabilityScore(delayMS, timeoutMS int, rel, weight float64) float64 {
+	scaled := 1.0
+	if timeoutMS > 0 {
+		scaled = math.Min(float64(delayMS)/float64(timeoutMS), 1)
+	}
+	return (1-weight)*scaled + weight*(1-rel)
+}
+
+// reliableCandidates orders alternatives by ascending reliabilityScore, ties
+// going to the faster node.
+func reliableCandidates(delays []ProxyDelay, current string, cfg Config) []ProxyDelay {
+	type scored struct {
+		item  ProxyDelay
+		score float64
+	}
+	ranked := make([]scored, 0, len(delays))
+	for _, item := range delays {
+		if item.Name == current {
+			continue
+		}
+		rel := reliability(cfg.reliability, item.Name)
+		ranked = append(ranked, scored{item: item, score: reliabilityScore(item.DelayMS, cfg.DelayTimeoutMS, rel, cfg.ReliabilityWeight)})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score < ranked[j].score
+	})
+	out := make([]ProxyDelay, 0, len(ranked))
+	for _, entry := range ranked {
+		out = append(out, entry.item)
+	}
+	return out
+}
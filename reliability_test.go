@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestReliabilityScoreBlend(t *testing.T) {
+	cases := []struct {
+		delayMS int
+		rel     float64
+		weight  float64
+		want    float64
+	}{
+		{300, 1, 0, 0.1},
+		{300, 0.5, 1, 0.5},
+		{300, 0.5, 0.5, 0.3},
+		{6000, 1, 0.5, 0.5}, // delay capped at the timeout
+	}
+	for _, tc := range cases {
+		if got := reliabilityScore(tc.delayMS, 3000, tc.rel, tc.weight); math.Abs(got-tc.want) > 1e-9 {
+			t.Fatalf("reliabilityScore(%d, %v, %v) = %v want %v", tc.delayMS, tc.rel, tc.weight, got, tc.want)
+		}
+	}
+}
+
+func TestReliabilitySmoothing(t *testing.T) {
+	window := newDelayWindow(4)
+	if got := reliability(window, "new"); got != 0.5 {
+		t.Fatalf("unknown node should score 0.5, got %v", got)
+	}
+	if got := reliability(nil, "new"); got != 0.5 {
+		t.Fatalf("no history should score 0.5, got %v", got)
+	}
+	window.record([]ProxyDelay{{Name: "steady", DelayMS: 200}, {Name: "flaky", DelayMS: 50}})
+	window.record([]ProxyDelay{{Name: "steady", DelayMS: 210}})
+	window.record([]ProxyDelay{{Name: "steady", DelayMS: 190}})
+	if got := reliability(window, "steady"); got != 0.8 {
+		t.Fatalf("3/3 answered should score 4/5, got %v", got)
+	}
+	if got := reliability(window, "flaky"); got != 0.4 {
+		t.Fatalf("1/3 answered should score 2/5, got %v", got)
+	}
+}
+
+func TestReliableCandidatesPreferConsistentNodes(t *testing.T) {
+	window := newDelayWindow(10)
+	for i := 0; i < 8; i++ {
+		round := []ProxyDelay{{Name: "steady", DelayMS: 400}, {Name: "current", DelayMS: 2500}}
+		if i%4 == 0 {
+			round = append(round, ProxyDelay{Name: "flaky", DelayMS: 80})
+		}
+		window.record(round)
+	}
+	delays := []ProxyDelay{{Name: "flaky", DelayMS: 80}, {Name: "steady", DelayMS: 400}, {Name: "current", DelayMS: 2500}}
+
+	cfg := Config{SelectStrategy: "reliable", DelayTimeoutMS: 3000, ReliabilityWeight: 0.5, reliability: window}
+	if got := orderCandidates(delays, "current", cfg); len(got) != 2 || got[0].Name != "steady" {
+		t.Fatalf("expected the consistent node first, got %v", got)
+	}
+	cfg.ReliabilityWeight = 0
+	if got := orderCandidates(delays, "current", cfg); got[0].Name != "flaky" {
+		t.Fatalf("weight 0 should order by delay only, got %v", got)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var scheduleWindowRE = regexp.MustCompile(`^(\d{1,2}):(\d{2})-(\d{1,2}):(\d{2}):(.+)$`)
+
+// scheduleWindow overrides thresholds between two local times of day. A window
+// whose end is before its start wraps around midnight.
+type scheduleWindow struct {
+	Label    string
+	StartMin int
+	EndMin   int
+	KeepMS   *int
+	DiffMS   *int
+}
+
+func (w scheduleWindow) contains(minute int) bool {
+	if w.StartMin < w.EndMin {
+		return minute >= w.StartMin && minute < w.EndMin
+	}
+	return minute >= w.StartMin || minute < w.EndMin
+}
+
+// parseSchedule parses SCHEDULE: semicolon-separated windows of the form
+// HH:MM-HH:MM:keep=MS,diff=MS, where either override may be omitted.
+func parseSchedule(raw string) ([]scheduleWindow, error) {
+	windows := make([]scheduleWindow, 0)
+	for _, item := range strings.Split(raw, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		match := scheduleWindowRE.FindStringSubmatch(item)
+		if match == nil {
+			return nil, fmt.Errorf("SCHEDULE window %q must look like 09:00-18:00:keep=800,diff=200", item)
+		}
+		start, err := scheduleMinute(match[1], match[2])
+		if err != nil {
+			return nil, fmt.Errorf("SCHEDULE window %q: %v", item, err)
+		}
+		end, err := scheduleMinute(match[3], match[4])
+		if err != nil {
+			return nil, fmt.Errorf("SCHEDULE window %q: %v", item, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("SCHEDULE window %q is empty", item)
+		}
+		window := scheduleWindow{Label: fmt.Sprintf("%s:%s-%s:%s", match[1], match[2], match[3], match[4]), StartMin: start, EndMin: end}
+		for _, override := range strings.Split(match[5], ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(override), "=")
+			if !ok {
+				return nil, fmt.Errorf("SCHEDULE window %q: override %q must be key=value", item, override)
+			}
+			ms, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || ms < 0 {
+				return nil, fmt.Errorf("SCHEDULE window %q: %s must be an integer >= 0", item, key)
+			}
+			switch strings.TrimSpace(key) {
+			case "keep":
+				window.KeepMS = &ms
+			case "diff":
+				window.DiffMS = &ms
+			default:
+				return nil, fmt.Errorf("SCHEDULE window %q: unknown override %q (want keep or diff)", item, key)
+			}
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+func scheduleMinute(hour, minute string) (int, error) {
+	h, _ := strconv.Atoi(hour)
+	m, _ := strconv.Atoi(minute)
+	if h > 23 || m > 59 {
+		return 0, fmt.Errorf("invalid time %s:%s", hour, minute)
+	}
+	return h*60 + m, nil
+}
+
+// activeScheduleWindow returns the first window containing now; earlier
+// windows win where they overlap.
+func activeScheduleWindow(windows []scheduleWindow, now time.Time) (scheduleWindow, bool) {
+	minute := now.Hour()*60 + now.Minute()
+	for _, window := range windows {
+		if window.contains(minute) {
+			return window, true
+		}
+	}
+	return scheduleWindow{}, false
+}
+
+// applySchedule returns cfg with the thresholds of the window active at now,
+// and that window's label ("" outside all windows).
+func applySchedule(cfg Config, now time.Time) (Config, string) {
+	window, ok := activeScheduleWindow(cfg.Schedule, now)
+	if !ok {
+		return cfg, ""
+	}
+	if window.KeepMS != nil {
+		cfg.KeepDelayThresholdMS = *window.KeepMS
+	}
+	if window.DiffMS != nil {
+		cfg.AutoSelectDiffMS = *window.DiffMS
+	}
+	return cfg, window.Label
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleRejectsInvalidWindows(t *testing.T) {
+	cases := map[string]string{
+		"9-18:keep=800":                  "must look like",
+		"25:00-18:00:keep=800":           "invalid time",
+		"09:00-09:00:keep=800":           "is empty",
+		"09:00-18:00:keep=-1":            "keep must be an integer >= 0",
+		"09:00-18:00:keep":               "must be key=value",
+		"09:00-18:00:keep=800,ratio=2":   "unknown override",
+		"09:00-18:00:keep=800;bad-entry": "must look like",
+	}
+	for raw, want := range cases {
+		if _, err := parseSchedule(raw); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("parseSchedule(%q) error=%v want %q", raw, err, want)
+		}
+	}
+	if windows, err := parseSchedule(""); err != nil || len(windows) != 0 {
+		t.Fatalf("empty schedule should parse to no windows, got %v %v", windows, err)
+	}
+}
+
+func TestActiveScheduleWindow(t *testing.T) {
+	windows, err := parseSchedule("09:00-18:00:keep=800,diff=200; 22:00-06:00:keep=3000; 12:00-13:00:diff=50")
+	if err != nil {
+		t.Fatalf("parseSchedule: %v", err)
+	}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2025, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	cases := []struct {
+		now  time.Time
+		want string
+	}{
+		{at(8, 59), ""},
+		{at(9, 0), "09:00-18:00"},
+		{at(12, 30), "09:00-18:00"}, // overlap: the earlier window wins
+		{at(17, 59), "09:00-18:00"},
+		{at(18, 0), ""},
+		{at(23, 30), "22:00-06:00"},
+		{at(0, 0), "22:00-06:00"},
+		{at(5, 59), "22:00-06:00"},
+		{at(6, 0), ""},
+	}
+	for _, tc := range cases {
+		window, ok := activeScheduleWindow(windows, tc.now)
+		if got := window.Label; ok != (tc.want != "") || got != tc.want {
+			t.Fatalf("activeScheduleWindow(%s)=%q,%v want %q", tc.now.Format("15:04"), got, ok, tc.want)
+		}
+	}
+}
+
+func TestApplyScheduleOverridesThresholds(t *testing.T) {
+	windows, err := parseSchedule("09:00-18:00:keep=800,diff=200;22:00-06:00:keep=3000")
+	if err != nil {
+		t.Fatalf("parseSchedule: %v", err)
+	}
+	base := Config{KeepDelayThresholdMS: 2000, AutoSelectDiffMS: 300, Schedule: windows}
+
+	cfg, label := applySchedule(base, time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local))
+	if label != "09:00-18:00" || cfg.KeepDelayThresholdMS != 800 || cfg.AutoSelectDiffMS != 200 {
+		t.Fatalf("work hours not applied: %q keep=%d diff=%d", label, cfg.KeepDelayThresholdMS, cfg.AutoSelectDiffMS)
+	}
+	cfg, label = applySchedule(base, time.Date(2025, 1, 1, 23, 0, 0, 0, time.Local))
+	if label != "22:00-06:00" || cfg.KeepDelayThresholdMS != 3000 || cfg.AutoSelectDiffMS != 300 {
+		t.Fatalf("night window not applied: %q keep=%d diff=%d", label, cfg.KeepDelayThresholdMS, cfg.AutoSelectDiffMS)
+	}
+	cfg, label = applySchedule(base, time.Date(2025, 1, 1, 19, 0, 0, 0, time.Local))
+	if label != "" || cfg.KeepDelayThresholdMS != 2000 || cfg.AutoSelectDiffMS != 300 {
+		t.Fatalf("global thresholds expected outside windows: %q keep=%d diff=%d", label, cfg.KeepDelayThresholdMS, cfg.AutoSelectDiffMS)
+	}
+}
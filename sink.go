@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	resultSinkQueueSize = 64
+	resultSinkRetries   = 3
+	resultSinkBackoff   = time.Second
+	resultSinkTimeout   = 5 * time.Second
+)
+
+// resultSink posts cycle results to RESULT_SINK_URL from a background worker.
+// The queue is bounded and push never blocks; results are dropped when the
+// sink falls behind so a slow collector cannot stall monitoring.
+type resultSink struct {
+	url         string
+	format      string
+	contentType string
+	client      *http.Client
+	queue       chan []byte
+	done        chan struct{}
+}
+
+func newResultSink(sinkURL, format string) (*resultSink, error) {
+	transport, err := buildBaseTransportNoEnvProxy()
+	if err != nil {
+		return nil, err
+	}
+	sink := &resultSink{
+		url:         sinkURL,
+		format:      format,
+		contentType: "application/json",
+		client:      &http.Client{Transport: transport, Timeout: resultSinkTimeout},
+		queue:       make(chan []byte, resultSinkQueueSize),
+		done:        make(chan struct{}),
+	}
+	if format == eventFormatCloudEvents {
+		sink.contentType = "application/cloudevents+json"
+	}
+	go sink.run()
+	return sink, nil
+}
+
+func (s *resultSink) push(result map[string]any) {
+	if s == nil || result == nil {
+		return
+	}
+	payload := make(map[string]any, len(result)+2)
+	for key, value := range result {
+		payload[key] = value
+	}
+	now := time.Now()
+	payload["time"] = now.UTC().Format(time.RFC3339)
+	payload["schema_version"] = jsonSchemaVersion
+	body, _ := encodeEvent(s.format, eventTypeResult, payload, now)
+
+	select {
+	case s.queue <- body:
+	default:
+		logWarnf("Result sink queue full, dropping result")
+	}
+}
+
+// close stops accepting results and waits up to timeout for queued ones.
+func (s *resultSink) close(timeout time.Duration) {
+	if s == nil {
+		return
+	}
+	close(s.queue)
+	select {
+	case <-s.done:
+	case <-time.After(timeout):
+		logWarnf("Result sink did not drain within %s", timeout)
+	}
+}
+
+func (s *resultSink) run() {
+	defer close(s.done)
+	for body := range s.queue {
+		backoff := resultSinkBackoff
+		for attempt := 0; ; attempt++ {
+			err := s.post(body)
+			if err == nil {
+				break
+			}
+			if attempt >= resultSinkRetries {
+				logWarnf("Result sink post failed, dropping result: %v", err)
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func (s *resultSink) post(body []byte) error {
+	resp, err := s.client.Post(s.url, s.contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sink responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResultSinkRetriesAndDeliversPayload(t *testing.T) {
+	var calls int32
+	received := make(chan map[string]any, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		raw, _ := io.ReadAll(r.Body)
+		var payload map[string]any
+		_ = json.Unmarshal(raw, &payload)
+		received <- payload
+	}))
+	defer server.Close()
+
+	sink, err := newResultSink(server.URL, eventFormatJSON)
+	if err != nil {
+		t.Fatalf("newResultSink failed: %v", err)
+	}
+	sink.push(map[string]any{"action": "kept", "reason": "ok"})
+	sink.close(5 * time.Second)
+
+	select {
+	case payload := <-received:
+		if payload["action"] != "kept" || payload["time"] == nil {
+			t.Fatalf("unexpected sink payload: %#v", payload)
+		}
+	default:
+		t.Fatalf("sink did not deliver payload")
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("expected 2 sink requests, got %d", got)
+	}
+}
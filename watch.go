@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// delayWindow keeps the last size delay samples of every proxy seen by
+// --watch; -1 marks a sample in which the proxy did not answer.
+type delayWindow struct {
+	size    int
+	samples map[string][]int
+}
+
+func newDelayWindow(size int) *delayWindow {
+	return &delayWindow{size: size, samples: make(map[string][]int)}
+}
+
+// record adds one sample round. Known proxies missing from delays get a
+// timeout sample, and proxies that have not answered for a whole window are
+// dropped, so nodes removed from the group eventually disappear.
+func (w *delayWindow) record(delays []ProxyDelay) {
+	seen := make(map[string]bool, len(delays))
+	for _, item := range delays {
+		seen[item.Name] = true
+		w.samples[item.Name] = w.push(w.samples[item.Name], item.DelayMS)
+	}
+	for name, samples := range w.samples {
+		if seen[name] {
+			continue
+		}
+		samples = w.push(samples, -1)
+		if len(samples) == w.size && answeredSamples(samples) == nil {
+			delete(w.samples, name)
+			continue
+		}
+		w.samples[name] = samples
+	}
+}
+
+func (w *delayWindow) push(samples []int, delayMS int) []int {
+	samples = append(samples, delayMS)
+	if len(samples) > w.size {
+		samples = samples[len(samples)-w.size:]
+	}
+	return samples
+}
+
+func answeredSamples(samples []int) []int {
+	var answered []int
+	for _, delayMS := range samples {
+		if delayMS >= 0 {
+			answered = append(answered, delayMS)
+		}
+	}
+	return answered
+}
+
+// WatchEntry is one proxy's line in a --watch round.
+type WatchEntry struct {
+	Name     string `json:"name"`
+	DelayMS  *int   `json:"delay_ms"`
+	P50MS    *int   `json:"p50_ms"`
+	P95MS    *int   `json:"p95_ms"`
+	Samples  int    `json:"samples"`
+	Timeouts int    `json:"timeouts"`
+}
+
+// summary reports every tracked proxy ordered by p50, with proxies that
+// timed out throughout the window last.
+func (w *delayWindow) summary() []WatchEntry {
+	entries := make([]WatchEntry, 0, len(w.samples))
+	for name, samples := range w.samples {
+		entry := WatchEntry{Name: name, Samples: len(samples)}
+		if last := samples[len(samples)-1]; last >= 0 {
+			entry.DelayMS = &last
+		}
+		answered := answeredSamples(samples)
+		entry.Timeouts = len(samples) - len(answered)
+		if len(answered) > 0 {
+			sort.Ints(answered)
+			p50, p95 := percentile(answered, 50), percentile(answered, 95)
+			entry.P50MS, entry.P95MS = &p50, &p95
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if (a.P50MS == nil) != (b.P50MS == nil) {
+			return b.P50MS == nil
+		}
+		if a.P50MS != nil && *a.P50MS != *b.P50MS {
+			return *a.P50MS < *b.P50MS
+		}
+		return a.Name < b.Name
+	})
+	return entries
+}
+
+func watchDelay(delayMS *int, cfg Config) string {
+	if delayMS == nil {
+		return "timeout"
+	}
+	return formatDelay(*delayMS, cfg)
+}
+
+func printWatchRound(out io.Writer, cfg Config, now time.Time, entries []WatchEntry, jsonOutput bool) {
+	timestamp := now.Format(time.RFC3339)
+	if jsonOutput {
+		fmt.Fprintln(out, mustASCIIJSON(map[string]any{"timestamp": timestamp, "test_url": cfg.TestURL, "proxies": entries, "schema_version": jsonSchemaVersion}))
+		return
+	}
+	fmt.Fprintf(out, "timestamp\t%s\n", timestamp)
+	for _, entry := range entries {
+		fmt.Fprintf(out, "%s\tp50=%s\tp95=%s\t%d/%d\t%s\n", watchDelay(entry.DelayMS, cfg), watchDelay(entry.P50MS, cfg),
+			watchDelay(entry.P95MS, cfg), entry.Samples-entry.Timeouts, entry.Samples, sanitizeName(entry.Name))
+	}
+}
+
+// watchDelays samples the group delays every interval until stop fires and
+// prints each round with rolling percentiles over the last WATCH_WINDOW rounds.
+// With CACHE_TTL_S, rounds read the background cache and only new samples are
+// recorded.
+func watchDelays(client *http.Client, cfg Config, interval time.Duration, jsonOutput bool, stop <-chan os.Signal) {
+	window := newDelayWindow(cfg.WatchWindow)
+	var lastSample time.Time
+	for {
+		if cfg.delays != nil {
+			delays, fetchedAt, ok := cfg.delays.get()
+			if ok && fetchedAt.After(lastSample) {
+				lastSample = fetchedAt
+				window.record(delays)
+				printWatchRound(os.Stdout, cfg, fetchedAt, window.summary(), jsonOutput)
+			}
+		} else if delays := getGroupDelays(client, cfg); len(delays) == 0 {
+			logWarnf("No delay data returned, round not recorded")
+		} else {
+			window.record(delays)
+			printWatchRound(os.Stdout, cfg, time.Now(), window.summary(), jsonOutput)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDelayWindowRollingPercentiles(t *testing.T) {
+	window := newDelayWindow(4)
+	rounds := [][]ProxyDelay{
+		{{Name: "steady", DelayMS: 100}, {Name: "spiky", DelayMS: 50}},
+		{{Name: "steady", DelayMS: 110}, {Name: "spiky", DelayMS: 900}},
+		{{Name: "steady", DelayMS: 120}},
+		{{Name: "steady", DelayMS: 130}, {Name: "spiky", DelayMS: 60}},
+		{{Name: "steady", DelayMS: 140}, {Name: "spiky", DelayMS: 70}},
+	}
+	for _, round := range rounds {
+		window.record(round)
+	}
+
+	entries := window.summary()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	spiky, steady := entries[0], entries[1]
+	if spiky.Name != "spiky" || *spiky.P50MS != 70 || *spiky.P95MS != 900 || spiky.Samples != 4 || spiky.Timeouts != 1 {
+		t.Fatalf("unexpected spiky entry %+v p50=%d p95=%d", spiky, *spiky.P50MS, *spiky.P95MS)
+	}
+	if steady.Name != "steady" || *steady.P50MS != 120 || *steady.P95MS != 140 || *steady.DelayMS != 140 || steady.Samples != 4 {
+		t.Fatalf("unexpected steady entry %+v", steady)
+	}
+}
+
+func TestDelayWindowHandlesMembershipChanges(t *testing.T) {
+	window := newDelayWindow(3)
+	window.record([]ProxyDelay{{Name: "A", DelayMS: 100}, {Name: "B", DelayMS: 200}})
+	window.record([]ProxyDelay{{Name: "A", DelayMS: 100}, {Name: "C", DelayMS: 300}})
+
+	entries := window.summary()
+	if len(entries) != 3 {
+		t.Fatalf("expected A, B and C to be tracked, got %+v", entries)
+	}
+	if entries[2].Name != "C" || entries[2].Samples != 1 {
+		t.Fatalf("new proxy should start with one sample, got %+v", entries[2])
+	}
+	if entries[1].Name != "B" || entries[1].DelayMS != nil || entries[1].Timeouts != 1 {
+		t.Fatalf("missing proxy should record a timeout, got %+v", entries[1])
+	}
+
+	// B has not answered for a full window once its answered sample rolls off.
+	window.record([]ProxyDelay{{Name: "A", DelayMS: 100}, {Name: "C", DelayMS: 300}})
+	window.record([]ProxyDelay{{Name: "A", DelayMS: 100}, {Name: "C", DelayMS: 300}})
+	for _, entry := range window.summary() {
+		if entry.Name == "B" {
+			t.Fatalf("proxy gone for a whole window should be dropped, got %+v", entry)
+		}
+	}
+}
+
+func TestPrintWatchRound(t *testing.T) {
+	p50, p95, last := 120, 400, 130
+	entries := []WatchEntry{
+		{Name: "JP 01", DelayMS: &last, P50MS: &p50, P95MS: &p95, Samples: 5, Timeouts: 1},
+		{Name: "Dead", Samples: 2, Timeouts: 2},
+	}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var text bytes.Buffer
+	printWatchRound(&text, Config{}, now, entries, false)
+	want := "timestamp\t2025-01-01T00:00:00Z\n130ms\tp50=120ms\tp95=400ms\t4/5\tJP 01\ntimeout\tp50=timeout\tp95=timeout\t0/2\tDead\n"
+	if text.String() != want {
+		t.Fatalf("unexpected text output %q", text.String())
+	}
+
+	var ndjson bytes.Buffer
+	printWatchRound(&ndjson, Config{TestURL: "https://example.com"}, now, entries, true)
+	if strings.Count(ndjson.String(), "\n") != 1 {
+		t.Fatalf("expected one NDJSON line per round, got %q", ndjson.String())
+	}
+	var payload struct {
+		Proxies []map[string]any `json:"proxies"`
+	}
+	if err := json.Unmarshal(ndjson.Bytes(), &payload); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(payload.Proxies) != 2 || payload.Proxies[0]["p95_ms"] != float64(400) || payload.Proxies[1]["p50_ms"] != nil {
+		t.Fatalf("unexpected NDJSON payload %+v", payload.Proxies)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const webhookTimeout = 5 * time.Second
+
+// switchWebhook POSTs successful switches and ALERT_ONLY recommendations to
+// NOTIFY_WEBHOOK_URL. With a batch interval, performance events are collected
+// and sent as one digest per interval; emergency events flush the digest right
+// away. Posting happens in
+// the background, so a slow webhook never delays a cycle.
+type switchWebhook struct {
+	url    string
+	format string
+	client *http.Client
+	batch  time.Duration
+
+	mu      sync.Mutex
+	pending []map[string]any
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newSwitchWebhook(webhookURL, format string, batch time.Duration) (*switchWebhook, error) {
+	transport, err := buildBaseTransportNoEnvProxy()
+	if err != nil {
+		return nil, err
+	}
+	w := &switchWebhook{
+		url:    webhookURL,
+		format: format,
+		client: &http.Client{Transport: transport, Timeout: webhookTimeout},
+		batch:  batch,
+		stop:   make(chan struct{}),
+	}
+	if batch > 0 {
+		w.wg.Add(1)
+		go w.run()
+	}
+	return w, nil
+}
+
+// switchEvent is the webhook body for one switch:
+//
+//	{"switch_type":"performance","from":"JP-01","to":"SG-02",
+//	 "from_delay_ms":2400,"to_delay_ms":180,"reason":"...",
+//	 "text":"Switched JP-01 (2400ms) -> SG-02 (180ms): ...",
+//	 "content":"<same as text>","timestamp":"2025-01-01T00:00:00Z",
+//	 "schema_version":2}
+//
+// from_delay_ms is null when the old node did not answer, and "group" is
+// added for multi-group runs. text and content carry a one-line summary so
+// Slack (text) and Discord (content) incoming webhooks accept the body as is.
+func switchEvent(result map[string]any, now time.Time) map[string]any {
+	event := map[string]any{"timestamp": now.UTC().Format(time.RFC3339), "schema_version": jsonSchemaVersion}
+	for _, key := range []string{"switch_type", "from", "to", "from_delay_ms", "to_delay_ms", "reason"} {
+		event[key] = result[key]
+	}
+	if group, ok := result["group"].(string); ok {
+		event["group"] = group
+	}
+	summary := switchSummary(event)
+	event["text"], event["content"] = summary, summary
+	return event
+}
+
+// alertEvent is the webhook body for an ALERT_ONLY recommendation: the
+// switchEvent fields plus "alert", with a "Recommend switching" summary.
+func alertEvent(result map[string]any, now time.Time) map[string]any {
+	event := switchEvent(result, now)
+	event["alert"] = result["alert"]
+	summary := switchSummary(event)
+	event["text"], event["content"] = summary, summary
+	return event
+}
+
+func switchSummary(event map[string]any) string {
+	delayText := func(value any) string {
+		if delayMS, ok := value.(*int); ok {
+			value = nil
+			if delayMS != nil {
+				value = *delayMS
+			}
+		}
+		if delayMS, ok := toInt(value); ok {
+			return fmt.Sprintf("%dms", delayMS)
+		}
+		return "timeout"
+	}
+	prefix := "Switched"
+	if _, ok := event["alert"]; ok {
+		prefix = "Recommend switching"
+	}
+	if group, ok := event["group"].(string); ok {
+		prefix += " " + group
+	}
+	if event["switch_type"] == "emergency" {
+		prefix = "Emergency: " + prefix
+	}
+	return fmt.Sprintf("%s %v (%s) -> %v (%s): %v", prefix, event["from"], delayText(event["from_delay_ms"]),
+		event["to"], delayText(event["to_delay_ms"]), event["reason"])
+}
+
+// notify sends result if it is a successful switch or carries an ALERT_ONLY
+// recommendation; other results are ignored.
+func (w *switchWebhook) notify(result map[string]any) {
+	if w == nil {
+		return
+	}
+	var event map[string]any
+	eventType := eventTypeSwitch
+	switch {
+	case result["action"] == "switched":
+		event = switchEvent(result, time.Now())
+	case result["alert"] != nil:
+		event, eventType = alertEvent(result, time.Now()), eventTypeAlert
+	default:
+		return
+	}
+	if w.batch <= 0 {
+		w.send(eventType, event)
+		return
+	}
+	w.mu.Lock()
+	w.pending = append(w.pending, event)
+	w.mu.Unlock()
+	if event["switch_type"] == "emergency" {
+		w.flush()
+	}
+}
+
+func (w *switchWebhook) run() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.batch)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.flush()
+		}
+	}
+}
+
+// flush sends the pending events as one digest, if there are any.
+func (w *switchWebhook) flush() {
+	w.mu.Lock()
+	events := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+	lines := make([]string, 0, len(events))
+	for _, event := range events {
+		lines = append(lines, event["text"].(string))
+	}
+	summary := strings.Join(lines, "\n")
+	w.send(eventTypeSwitchDigest, map[string]any{
+		"events":         events,
+		"count":          len(events),
+		"text":           summary,
+		"content":        summary,
+		"timestamp":      time.Now().UTC().Format(time.RFC3339),
+		"schema_version": jsonSchemaVersion,
+	})
+}
+
+func (w *switchWebhook) send(eventType string, body map[string]any) {
+	payload, contentType := encodeEvent(w.format, eventType, body, time.Now())
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		if err := w.post(payload, contentType); err != nil {
+			logWarnf("Switch webhook failed: %v", err)
+		}
+	}()
+}
+
+func (w *switchWebhook) post(body []byte, contentType string) error {
+	resp, err := w.client.Post(w.url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// close flushes any pending digest and waits up to timeout for in-flight
+// posts.
+func (w *switchWebhook) close(timeout time.Duration) {
+	if w == nil {
+		return
+	}
+	if w.batch > 0 {
+		close(w.stop)
+	}
+	w.flush()
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logWarnf("Switch webhook did not finish within %s", timeout)
+	}
+}
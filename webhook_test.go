@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newWebhookServer(t *testing.T) (*httptest.Server, chan map[string]any) {
+	t.Helper()
+	received := make(chan map[string]any, 8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var payload map[string]any
+		_ = json.Unmarshal(raw, &payload)
+		received <- payload
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func switched(switchType, to string) map[string]any {
+	return map[string]any{"action": "switched", "switch_type": switchType, "from": "A", "to": to, "to_delay_ms": 100, "reason": "faster"}
+}
+
+func TestSwitchWebhookImmediate(t *testing.T) {
+	server, received := newWebhookServer(t)
+	webhook, err := newSwitchWebhook(server.URL, eventFormatJSON, 0)
+	if err != nil {
+		t.Fatalf("newSwitchWebhook: %v", err)
+	}
+	webhook.notify(map[string]any{"action": "kept"})
+	webhook.notify(switched("performance", "B"))
+	webhook.close(5 * time.Second)
+
+	if len(received) != 1 {
+		t.Fatalf("expected one post for the switch only, got %d", len(received))
+	}
+	payload := <-received
+	if payload["to"] != "B" || payload["switch_type"] != "performance" || payload["timestamp"] == nil || payload["events"] != nil {
+		t.Fatalf("unexpected immediate payload %#v", payload)
+	}
+}
+
+func TestSwitchEventSummary(t *testing.T) {
+	before := 2400
+	result := map[string]any{"action": "switched", "switch_type": "performance", "from": "JP-01", "to": "SG-02",
+		"from_delay_ms": &before, "to_delay_ms": 180, "reason": "faster", "group": "Streaming"}
+	event := switchEvent(result, time.Now())
+	want := "Switched Streaming JP-01 (2400ms) -> SG-02 (180ms): faster"
+	if event["text"] != want || event["content"] != want || event["group"] != "Streaming" {
+		t.Fatalf("unexpected event %#v", event)
+	}
+
+	result = switched("emergency", "B")
+	result["from_delay_ms"] = (*int)(nil)
+	event = switchEvent(result, time.Now())
+	if event["text"] != "Emergency: Switched A (timeout) -> B (100ms): faster" {
+		t.Fatalf("unexpected emergency summary %q", event["text"])
+	}
+	if _, ok := event["group"]; ok {
+		t.Fatalf("single-group events should not carry group: %#v", event)
+	}
+}
+
+func TestSwitchWebhookBatchesAndFlushesOnClose(t *testing.T) {
+	server, received := newWebhookServer(t)
+	webhook, err := newSwitchWebhook(server.URL, eventFormatJSON, time.Hour)
+	if err != nil {
+		t.Fatalf("newSwitchWebhook: %v", err)
+	}
+	webhook.notify(switched("performance", "B"))
+	webhook.notify(switched("performance", "C"))
+	time.Sleep(50 * time.Millisecond)
+	if len(received) != 0 {
+		t.Fatalf("performance switches should wait for the batch interval")
+	}
+
+	webhook.notify(switched("emergency", "D"))
+	select {
+	case digest := <-received:
+		events, _ := digest["events"].([]any)
+		if len(events) != 3 || digest["count"] != float64(3) {
+			t.Fatalf("emergency should flush all pending events, got %#v", digest)
+		}
+		if last := events[2].(map[string]any); last["to"] != "D" {
+			t.Fatalf("events out of order: %#v", events)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("emergency switch did not flush the batch")
+	}
+
+	webhook.notify(switched("performance", "E"))
+	webhook.close(5 * time.Second)
+	select {
+	case digest := <-received:
+		if events, _ := digest["events"].([]any); len(events) != 1 {
+			t.Fatalf("shutdown should flush the pending event, got %#v", digest)
+		}
+	default:
+		t.Fatalf("pending batch was not flushed on close")
+	}
+}
+
+func TestSwitchWebhookBatchInterval(t *testing.T) {
+	server, received := newWebhookServer(t)
+	webhook, err := newSwitchWebhook(server.URL, eventFormatJSON, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("newSwitchWebhook: %v", err)
+	}
+	defer webhook.close(time.Second)
+	webhook.notify(switched("performance", "B"))
+	select {
+	case digest := <-received:
+		if events, _ := digest["events"].([]any); len(events) != 1 {
+			t.Fatalf("unexpected digest %#v", digest)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("batch was not flushed after the interval")
+	}
+}
+
+func TestSwitchWebhookSendsAlerts(t *testing.T) {
+	alert := map[string]any{"action": "would_switch", "dry_run": true, "switch_type": "performance", "from": "A", "to": "B",
+		"to_delay_ms": 100, "reason": "faster", "alert": "recommend switching from A to B"}
+
+	server, received := newWebhookServer(t)
+	webhook, err := newSwitchWebhook(server.URL, eventFormatCloudEvents, 0)
+	if err != nil {
+		t.Fatalf("newSwitchWebhook: %v", err)
+	}
+	webhook.notify(map[string]any{"action": "would_switch", "to": "B"})
+	webhook.notify(alert)
+	webhook.close(5 * time.Second)
+	if len(received) != 1 {
+		t.Fatalf("expected one post for the alert only, got %d", len(received))
+	}
+	envelope := <-received
+	data, _ := envelope["data"].(map[string]any)
+	if envelope["type"] != eventTypeAlert || data["alert"] != "recommend switching from A to B" ||
+		data["text"] != "Recommend switching A (timeout) -> B (100ms): faster" {
+		t.Fatalf("unexpected alert event %#v", envelope)
+	}
+
+	server, received = newWebhookServer(t)
+	webhook, err = newSwitchWebhook(server.URL, eventFormatJSON, time.Hour)
+	if err != nil {
+		t.Fatalf("newSwitchWebhook: %v", err)
+	}
+	webhook.notify(alert)
+	webhook.close(5 * time.Second)
+	digest := <-received
+	if events, _ := digest["events"].([]any); len(events) != 1 || events[0].(map[string]any)["alert"] == nil {
+		t.Fatalf("expected the alert in the digest, got %#v", digest)
+	}
+}